@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// channelConfig binds a channel to one of the built-in behaviors:
+//
+//	echo       responds with the message body
+//	broadcast  broadcasts the message body on Target, or on the channel itself
+//	webhook    POSTs the message to URL as JSON and responds with the response body
+//	exec       runs Command with the body on stdin and responds with each line of output
+type channelConfig struct {
+	Behavior string            `json:"behavior"`
+	Target   string            `json:"target"`
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers"`
+	Command  []string          `json:"command"`
+}
+
+// webhookRequest is the JSON body POSTed by the webhook behavior.
+type webhookRequest struct {
+	ID        string            `json:"id"`
+	Channel   string            `json:"channel"`
+	Body      string            `json:"body"`
+	Timestamp int64             `json:"timestamp"`
+	Session   string            `json:"session,omitempty"`
+	Principal *vessel.Principal `json:"principal,omitempty"`
+}
+
+// newBehavior returns the Handler for the channel's behavior. Broadcasts are
+// sent with broadcast.
+func newBehavior(cfg *channelConfig, broadcast func(string, string)) (vessel.Handler, error) {
+	switch cfg.Behavior {
+	case "echo":
+		return echo, nil
+	case "broadcast":
+		return broadcaster(cfg.Target, broadcast), nil
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("Webhook requires a url")
+		}
+		return webhook(cfg.URL, cfg.Headers), nil
+	case "exec":
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("Exec requires a command")
+		}
+		return execCommand(cfg.Command), nil
+	default:
+		return nil, fmt.Errorf("Unknown behavior %s", cfg.Behavior)
+	}
+}
+
+func echo(ctx context.Context, req *vessel.Request, results chan<- string) {
+	respond(ctx, results, req.Body)
+}
+
+// broadcaster returns a Handler which broadcasts message bodies on the target
+// channel, or on the channel they were sent on if the target is empty, making
+// the channel a pure pub/sub relay.
+func broadcaster(target string, broadcast func(string, string)) vessel.Handler {
+	return func(ctx context.Context, req *vessel.Request, results chan<- string) {
+		channel := target
+		if channel == "" {
+			channel = req.Channel
+		}
+		broadcast(channel, req.Body)
+	}
+}
+
+// webhook returns a Handler which POSTs requests to the URL and responds with
+// the body of successful responses.
+func webhook(url string, headers map[string]string) vessel.Handler {
+	return func(ctx context.Context, req *vessel.Request, results chan<- string) {
+		data, err := json.Marshal(&webhookRequest{
+			ID:        req.ID,
+			Channel:   req.Channel,
+			Body:      req.Body,
+			Timestamp: req.Timestamp,
+			Session:   req.Session,
+			Principal: req.Principal,
+		})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		httpReq, err := http.NewRequest("POST", url, bytes.NewReader(data))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		httpReq = httpReq.WithContext(ctx)
+		httpReq.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			httpReq.Header.Set(name, value)
+		}
+
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			log.Printf("Webhook %s returned %d: %s", url, resp.StatusCode, body)
+			return
+		}
+		if len(body) > 0 {
+			respond(ctx, results, string(body))
+		}
+	}
+}
+
+// execCommand returns a Handler which runs the command for each request with
+// the body on stdin, responding with each line it writes to stdout. The
+// command is killed if the Context is done before it exits. It doesn't
+// inherit the server's environment, which may hold secrets, only PATH and
+// the details of the request.
+func execCommand(command []string) vessel.Handler {
+	return func(ctx context.Context, req *vessel.Request, results chan<- string) {
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdin = strings.NewReader(req.Body)
+		cmd.Stderr = os.Stderr
+		cmd.Env = append([]string{},
+			"PATH="+os.Getenv("PATH"),
+			"VESSEL_MESSAGE_ID="+req.ID,
+			"VESSEL_CHANNEL="+req.Channel,
+			"VESSEL_SESSION="+req.Session,
+		)
+		if req.Principal != nil {
+			cmd.Env = append(cmd.Env, "VESSEL_PRINCIPAL="+req.Principal.ID)
+		}
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			log.Println(err)
+			return
+		}
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if !respond(ctx, results, scanner.Text()) {
+				break
+			}
+		}
+		// Drain any remaining output so the command isn't left blocked
+		// writing to the pipe.
+		io.Copy(io.Discard, stdout)
+		if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+			log.Printf("Command %s failed: %s", command[0], err)
+		}
+	}
+}
+
+// respond sends the result unless the Context is done first, returning false
+// if it was.
+func respond(ctx context.Context, results chan<- string, result string) bool {
+	select {
+	case results <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// handle runs the handler and returns its results.
+func handle(handler vessel.Handler, req *vessel.Request) []string {
+	results := make(chan string)
+	go func() {
+		handler(context.Background(), req, results)
+		close(results)
+	}()
+	var received []string
+	for result := range results {
+		received = append(received, result)
+	}
+	return received
+}
+
+// Ensures that newBehavior returns an error for unknown or incomplete
+// behaviors.
+func TestNewBehaviorInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newBehavior(&channelConfig{Behavior: "bogus"}, nil)
+	assert.NotNil(err)
+	_, err = newBehavior(&channelConfig{Behavior: "webhook"}, nil)
+	assert.NotNil(err)
+	_, err = newBehavior(&channelConfig{Behavior: "exec"}, nil)
+	assert.NotNil(err)
+}
+
+// Ensures that the echo behavior responds with the message body.
+func TestEcho(t *testing.T) {
+	handler, err := newBehavior(&channelConfig{Behavior: "echo"}, nil)
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"hello"}, handle(handler, &vessel.Request{Body: "hello"}))
+	}
+}
+
+// Ensures that the broadcast behavior broadcasts on the target channel or the
+// channel the message was sent on.
+func TestBroadcast(t *testing.T) {
+	assert := assert.New(t)
+	var broadcasts [][2]string
+	broadcast := func(channel, body string) {
+		broadcasts = append(broadcasts, [2]string{channel, body})
+	}
+
+	relay, _ := newBehavior(&channelConfig{Behavior: "broadcast"}, broadcast)
+	forward, _ := newBehavior(&channelConfig{Behavior: "broadcast", Target: "all"}, broadcast)
+	assert.Empty(handle(relay, &vessel.Request{Channel: "chat", Body: "hi"}))
+	handle(forward, &vessel.Request{Channel: "chat", Body: "bye"})
+
+	assert.Equal([][2]string{{"chat", "hi"}, {"all", "bye"}}, broadcasts)
+}
+
+// Ensures that the webhook behavior POSTs the request and responds with the
+// response body.
+func TestWebhook(t *testing.T) {
+	assert := assert.New(t)
+	var received webhookRequest
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &received)
+		w.Write([]byte("accepted " + received.Body))
+	}))
+	defer server.Close()
+
+	handler, err := newBehavior(&channelConfig{
+		Behavior: "webhook",
+		URL:      server.URL,
+		Headers:  map[string]string{"X-Secret": "s3cret"},
+	}, nil)
+	if !assert.Nil(err) {
+		return
+	}
+	results := handle(handler, &vessel.Request{ID: "abc", Channel: "orders", Body: "order 1"})
+
+	assert.Equal([]string{"accepted order 1"}, results)
+	assert.Equal(webhookRequest{ID: "abc", Channel: "orders", Body: "order 1"}, received)
+	assert.Equal("application/json", header.Get("Content-Type"))
+	assert.Equal("s3cret", header.Get("X-Secret"))
+}
+
+// Ensures that the webhook behavior doesn't respond when the webhook fails.
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	handler, _ := newBehavior(&channelConfig{Behavior: "webhook", URL: server.URL}, nil)
+
+	assert.Empty(t, handle(handler, &vessel.Request{Body: "order 1"}))
+}
+
+// Ensures that the exec behavior runs the command with the body on stdin and
+// responds with each line of output.
+func TestExec(t *testing.T) {
+	handler, err := newBehavior(&channelConfig{
+		Behavior: "exec",
+		Command:  []string{"sh", "-c", "echo $VESSEL_CHANNEL; cat"},
+	}, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	results := handle(handler, &vessel.Request{Channel: "run", Body: "one\ntwo"})
+
+	assert.Equal(t, []string{"run", "one", "two"}, results)
+}
+
+// Ensures that the exec behavior doesn't pass the server's environment to the
+// command.
+func TestExecEnvironment(t *testing.T) {
+	t.Setenv("VESSEL_SECRET", "hunter2")
+	handler, _ := newBehavior(&channelConfig{
+		Behavior: "exec",
+		Command:  []string{"sh", "-c", "echo \"secret=$VESSEL_SECRET\""},
+	}, nil)
+
+	results := handle(handler, &vessel.Request{Channel: "run"})
+
+	assert.Equal(t, []string{"secret="}, results)
+}
+
+// Ensures that the exec behavior kills the command when the Context is done.
+func TestExecCancel(t *testing.T) {
+	handler, _ := newBehavior(&channelConfig{Behavior: "exec", Command: []string{"sleep", "10"}}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		handler(ctx, &vessel.Request{}, make(chan string))
+		close(done)
+	}()
+	cancel()
+
+	<-done
+}
@@ -0,0 +1,271 @@
+// Package client implements Go clients for vessel servers.
+//
+// Clients connect either over a native WebSocket, which receives responses
+// and broadcasts as they're sent, or over the HTTP polling API, which
+// long-polls for them. Both reconnect automatically and resume subscriptions
+// from the last message received.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tylertreat/vessel/vessel"
+)
+
+const (
+	defaultReconnectWait    = time.Second
+	defaultMaxReconnectWait = 30 * time.Second
+	defaultPollWait         = 30 * time.Second
+
+	// dedupeWindow is how many seconds older than the newest message on a
+	// channel a message may be and still be recognized as a duplicate.
+	dedupeWindow = 60
+
+	// mailboxSize is how many messages may be waiting to be received on a
+	// response or subscription channel before the client stops reading.
+	mailboxSize = 64
+)
+
+// Client sends messages to a vessel server and receives responses and
+// broadcasts.
+type Client interface {
+	// Send sends the body on the channel and returns a channel which receives
+	// the handler's responses. The channel is closed once the handler has
+	// completed, if the transport reports it, when the Context is done, when
+	// the connection the message was sent on is lost, or when the Client is
+	// closed.
+	Send(context.Context, string, string) (<-chan *vessel.Message, error)
+
+	// Subscribe subscribes to the channel and returns a channel which
+	// receives the messages broadcast on it. The channel is closed when
+	// unsubscribing or closing the Client.
+	Subscribe(string) (<-chan *vessel.Message, error)
+
+	// Unsubscribe stops receiving broadcasts on the channel.
+	Unsubscribe(string) error
+
+	// Close disconnects the Client.
+	Close() error
+}
+
+// Options configures a Client.
+type Options struct {
+	// Token is presented to the server's Authenticator, if any.
+	Token string
+
+	// IDGenerator generates IDs for sent messages. Defaults to random
+	// hexadecimal strings.
+	IDGenerator vessel.IDGenerator
+
+	// HTTPClient is used by the HTTP client. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Dialer is used by the WebSocket client. Defaults to
+	// websocket.DefaultDialer.
+	Dialer *websocket.Dialer
+
+	// ReconnectWait is how long to wait before the first attempt to
+	// reconnect. It doubles after each failed attempt up to MaxReconnectWait.
+	// Defaults to one second.
+	ReconnectWait time.Duration
+
+	// MaxReconnectWait is the longest to wait between attempts to reconnect.
+	// Defaults to 30 seconds.
+	MaxReconnectWait time.Duration
+
+	// PollWait is how long the HTTP client's polls wait for new responses or
+	// messages. Defaults to 30 seconds.
+	PollWait time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.IDGenerator == nil {
+		o.IDGenerator = newID
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	if o.Dialer == nil {
+		o.Dialer = websocket.DefaultDialer
+	}
+	if o.ReconnectWait == 0 {
+		o.ReconnectWait = defaultReconnectWait
+	}
+	if o.MaxReconnectWait == 0 {
+		o.MaxReconnectWait = defaultMaxReconnectWait
+	}
+	if o.PollWait == 0 {
+		o.PollWait = defaultPollWait
+	}
+}
+
+// backoff returns how long to wait before the next attempt to reconnect.
+func (o *Options) backoff(wait time.Duration) time.Duration {
+	if wait == 0 {
+		return o.ReconnectWait
+	}
+	wait *= 2
+	if wait > o.MaxReconnectWait {
+		wait = o.MaxReconnectWait
+	}
+	return wait
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// mailbox delivers messages on a channel owned by its own goroutine so the
+// channel can be closed safely while messages are still arriving.
+type mailbox struct {
+	in         chan *vessel.Message
+	out        chan *vessel.Message
+	finished   chan struct{}
+	done       chan struct{}
+	closed     chan struct{}
+	finishOnce sync.Once
+	closeOnce  sync.Once
+}
+
+func newMailbox() *mailbox {
+	m := &mailbox{
+		in:       make(chan *vessel.Message, mailboxSize),
+		out:      make(chan *vessel.Message),
+		finished: make(chan struct{}),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// put delivers the message, blocking while the mailbox is full. It returns
+// false if the mailbox has been closed.
+func (m *mailbox) put(msg *vessel.Message) bool {
+	select {
+	case <-m.done:
+		return false
+	default:
+	}
+
+	select {
+	case m.in <- msg:
+		return true
+	case <-m.done:
+		return false
+	}
+}
+
+// finish closes the channel once the messages already put have been
+// received.
+func (m *mailbox) finish() {
+	m.finishOnce.Do(func() { close(m.finished) })
+}
+
+// close discards any messages which haven't been received and closes the
+// channel.
+func (m *mailbox) close() {
+	m.closeOnce.Do(func() { close(m.done) })
+}
+
+func (m *mailbox) run() {
+	defer close(m.closed)
+	defer close(m.out)
+	for {
+		select {
+		case msg := <-m.in:
+			if !m.deliver(msg) {
+				return
+			}
+		case <-m.finished:
+			for {
+				select {
+				case msg := <-m.in:
+					if !m.deliver(msg) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *mailbox) deliver(msg *vessel.Message) bool {
+	select {
+	case m.out <- msg:
+		return true
+	case <-m.done:
+		return false
+	}
+}
+
+// tracker remembers the newest timestamp received on a channel and the IDs of
+// recent messages so history replayed when resuming isn't delivered twice.
+type tracker struct {
+	last int64
+	seen map[string]int64
+}
+
+func newTracker() *tracker {
+	return &tracker{seen: map[string]int64{}}
+}
+
+// add records the message and returns false if it has already been received.
+func (t *tracker) add(msg *vessel.Message) bool {
+	if _, ok := t.seen[msg.ID]; ok {
+		return false
+	}
+	t.seen[msg.ID] = msg.Timestamp
+	if msg.Timestamp > t.last {
+		t.last = msg.Timestamp
+		for id, timestamp := range t.seen {
+			if timestamp < t.last-dedupeWindow {
+				delete(t.seen, id)
+			}
+		}
+	}
+	return true
+}
+
+// since returns the timestamp to resume from. Timestamps have a resolution of
+// a second, so it includes the second of the newest message, whose messages
+// are deduplicated. It returns zero if nothing has been received.
+func (t *tracker) since() int64 {
+	if t.last == 0 {
+		return 0
+	}
+	return t.last - 1
+}
+
+// cursor returns the timestamp to poll from and the IDs of the messages
+// already received with that timestamp, which the server leaves out so a poll
+// only returns once there's something new. Without any such IDs, it falls back
+// to since.
+func (t *tracker) cursor() (int64, []string) {
+	ids := []string{}
+	for id, timestamp := range t.seen {
+		if timestamp == t.last {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return t.since(), nil
+	}
+	sort.Strings(ids)
+	return t.last, ids
+}
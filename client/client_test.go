@@ -0,0 +1,97 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// Ensures that tracker recognizes duplicate messages and resumes from the
+// second of the newest message.
+func TestTracker(t *testing.T) {
+	assert := assert.New(t)
+	tracker := newTracker()
+	assert.Equal(int64(0), tracker.since())
+
+	assert.True(tracker.add(&vessel.Message{ID: "a", Timestamp: 100}))
+	assert.False(tracker.add(&vessel.Message{ID: "a", Timestamp: 100}))
+	assert.True(tracker.add(&vessel.Message{ID: "b", Timestamp: 100}))
+	assert.Equal(int64(99), tracker.since())
+
+	// Old IDs are forgotten once they fall outside the window.
+	assert.True(tracker.add(&vessel.Message{ID: "c", Timestamp: 100 + dedupeWindow + 1}))
+	assert.Len(tracker.seen, 1)
+}
+
+// Ensures that cursor names the messages received in the newest second, and
+// falls back to since before any have been.
+func TestTrackerCursor(t *testing.T) {
+	assert := assert.New(t)
+	tracker := newTracker()
+	tracker.last = 100
+
+	since, seen := tracker.cursor()
+	assert.Equal(int64(99), since)
+	assert.Nil(seen)
+
+	tracker.add(&vessel.Message{ID: "b", Timestamp: 100})
+	tracker.add(&vessel.Message{ID: "a", Timestamp: 100})
+	since, seen = tracker.cursor()
+	assert.Equal(int64(100), since)
+	assert.Equal([]string{"a", "b"}, seen)
+
+	tracker.add(&vessel.Message{ID: "c", Timestamp: 101})
+	since, seen = tracker.cursor()
+	assert.Equal(int64(101), since)
+	assert.Equal([]string{"c"}, seen)
+}
+
+// Ensures that mailbox delivers messages in order and discards the rest once
+// closed.
+func TestMailbox(t *testing.T) {
+	assert := assert.New(t)
+	m := newMailbox()
+
+	assert.True(m.put(&vessel.Message{ID: "a"}))
+	assert.True(m.put(&vessel.Message{ID: "b"}))
+	assert.Equal("a", (<-m.out).ID)
+	assert.Equal("b", (<-m.out).ID)
+
+	m.close()
+	assert.False(m.put(&vessel.Message{ID: "c"}))
+	select {
+	case _, ok := <-m.out:
+		assert.False(ok)
+	case <-time.After(time.Second):
+		t.Fatal("Mailbox not closed")
+	}
+}
+
+// Ensures that a finished mailbox delivers the messages already put before
+// closing its channel.
+func TestMailboxFinish(t *testing.T) {
+	assert := assert.New(t)
+	m := newMailbox()
+	m.put(&vessel.Message{ID: "a"})
+	m.put(&vessel.Message{ID: "b"})
+
+	m.finish()
+
+	ids := []string{}
+	for msg := range m.out {
+		ids = append(ids, msg.ID)
+	}
+	assert.Equal([]string{"a", "b"}, ids)
+}
+
+// Ensures that backoff doubles the wait up to the maximum.
+func TestOptionsBackoff(t *testing.T) {
+	assert := assert.New(t)
+	opts := &Options{ReconnectWait: time.Second, MaxReconnectWait: 3 * time.Second}
+
+	assert.Equal(time.Second, opts.backoff(0))
+	assert.Equal(2*time.Second, opts.backoff(time.Second))
+	assert.Equal(3*time.Second, opts.backoff(2*time.Second))
+}
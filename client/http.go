@@ -0,0 +1,309 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// statusError is returned when the server responds with an unexpected status.
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Unexpected status %d: %s", e.status, e.body)
+}
+
+// retryable indicates if a request which failed with the error may succeed
+// if it's repeated.
+func retryable(err error) bool {
+	if statusErr, ok := err.(*statusError); ok {
+		return statusErr.status >= http.StatusInternalServerError
+	}
+	return true
+}
+
+type httpClient struct {
+	url           string
+	opts          Options
+	subscriptions map[string]*mailbox
+	closed        bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	mu            sync.Mutex
+}
+
+// NewHTTPClient returns a Client which uses the vessel's HTTP polling API at
+// the URL, which is the vessel's URI on the server's HTTP port, e.g.
+// "http://localhost:8082/vessel". Responses and broadcasts are long-polled.
+// Nil Options use the defaults.
+func NewHTTPClient(url string, options *Options) Client {
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	opts.setDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &httpClient{
+		url:           strings.TrimSuffix(url, "/"),
+		opts:          opts,
+		subscriptions: map[string]*mailbox{},
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Send sends the body on the channel and returns a channel which receives the
+// handler's responses. The channel is closed once the handler has completed,
+// the Context is done, the result can't be polled or the Client is closed.
+func (c *httpClient) Send(ctx context.Context, channel, body string) (<-chan *vessel.Message, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	msg := &vessel.Message{
+		ID:        c.opts.IDGenerator(),
+		Channel:   channel,
+		Body:      body,
+		Timestamp: time.Now().Unix(),
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := c.do(ctx, "POST", c.url, bytes.NewReader(data), http.StatusAccepted); err != nil {
+		return nil, err
+	}
+
+	responses := newMailbox()
+	go c.pollResponses(ctx, msg.ID, responses)
+	return responses.out, nil
+}
+
+// Subscribe subscribes to the channel, long-polling it for messages broadcast
+// from now on. Messages missed while the server is unreachable are received
+// once it's reachable again.
+func (c *httpClient) Subscribe(channel string) (<-chan *vessel.Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if _, ok := c.subscriptions[channel]; ok {
+		return nil, fmt.Errorf("Already subscribed to %s", channel)
+	}
+
+	messages := newMailbox()
+	c.subscriptions[channel] = messages
+	go c.pollChannel(channel, messages)
+	return messages.out, nil
+}
+
+// Unsubscribe stops polling the channel and closes its channel.
+func (c *httpClient) Unsubscribe(channel string) error {
+	c.mu.Lock()
+	messages, ok := c.subscriptions[channel]
+	delete(c.subscriptions, channel)
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("Not subscribed to %s", channel)
+	}
+	messages.close()
+	return nil
+}
+
+// Close stops all polling and closes the channels of messages in flight and
+// subscriptions.
+func (c *httpClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	subscriptions := c.subscriptions
+	c.subscriptions = map[string]*mailbox{}
+	c.mu.Unlock()
+
+	c.cancel()
+	for _, messages := range subscriptions {
+		messages.close()
+	}
+	return nil
+}
+
+// pollResponses delivers the responses to the message until its handler has
+// completed.
+func (c *httpClient) pollResponses(ctx context.Context, id string, responses *mailbox) {
+	defer responses.finish()
+	ctx, cancel := c.context(ctx, responses)
+	defer cancel()
+
+	seen := 0
+	var wait time.Duration
+	for {
+		var result vessel.Result
+		query := url.Values{"seen": {strconv.Itoa(seen)}, "wait": {c.opts.PollWait.String()}}
+		err := c.get(ctx, "/message/"+url.PathEscape(id), query, &result)
+		if err != nil {
+			if !retryable(err) || !c.sleep(ctx, &wait) {
+				return
+			}
+			continue
+		}
+		wait = 0
+
+		if len(result.Responses) > seen {
+			for _, msg := range result.Responses[seen:] {
+				if !responses.put(msg) {
+					return
+				}
+			}
+			seen = len(result.Responses)
+		}
+		if result.Done {
+			return
+		}
+	}
+}
+
+// pollChannel delivers messages broadcast on the channel until unsubscribed.
+func (c *httpClient) pollChannel(channel string, messages *mailbox) {
+	defer messages.finish()
+	ctx, cancel := c.context(context.Background(), messages)
+	defer cancel()
+
+	path := "/channel/" + url.PathEscape(channel)
+	tracker := newTracker()
+	var wait time.Duration
+	for {
+		now, err := c.serverTime(ctx, path)
+		if err == nil {
+			tracker.last = now
+			break
+		}
+		if !retryable(err) || !c.sleep(ctx, &wait) {
+			return
+		}
+	}
+
+	wait = 0
+	for {
+		var history []*vessel.Message
+		since, seen := tracker.cursor()
+		query := url.Values{
+			"since":  {strconv.FormatInt(since, 10)},
+			"wait":   {c.opts.PollWait.String()},
+			"seenId": seen,
+		}
+		if err := c.get(ctx, path, query, &history); err != nil {
+			if !retryable(err) || !c.sleep(ctx, &wait) {
+				return
+			}
+			continue
+		}
+		wait = 0
+
+		for _, msg := range history {
+			if tracker.add(msg) && !messages.put(msg) {
+				return
+			}
+		}
+	}
+}
+
+// serverTime returns the server's current time as a timestamp so polling
+// starts from the server's clock rather than the client's. It's read from the
+// Date header of a poll which returns no messages.
+func (c *httpClient) serverTime(ctx context.Context, path string) (int64, error) {
+	query := url.Values{"since": {strconv.FormatInt(math.MaxInt64, 10)}}
+	header, _, err := c.do(ctx, "GET", c.url+path+"?"+query.Encode(), nil, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return time.Now().Unix(), nil
+	}
+	return date.Unix(), nil
+}
+
+// context returns a Context which is cancelled when the parent is done, the
+// mailbox is closed or the Client is closed.
+func (c *httpClient) context(parent context.Context, m *mailbox) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-m.done:
+		case <-c.ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// sleep waits before retrying a failed request, doubling the wait each time.
+// It returns false if the Context is done first.
+func (c *httpClient) sleep(ctx context.Context, wait *time.Duration) bool {
+	*wait = c.opts.backoff(*wait)
+	select {
+	case <-time.After(*wait):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// get requests the path under the vessel's URI and decodes the JSON response
+// into v.
+func (c *httpClient) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	_, body, err := c.do(ctx, "GET", c.url+path+"?"+query.Encode(), nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// do performs the request, returning the response's header and body or an
+// error if it doesn't have the expected status.
+func (c *httpClient) do(ctx context.Context, method, url string, body io.Reader,
+	status int) (http.Header, []byte, error) {
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != status {
+		return nil, nil, &statusError{status: resp.StatusCode, body: string(respBody)}
+	}
+	return resp.Header, respBody, nil
+}
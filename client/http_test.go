@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// Ensures that the HTTP client receives all responses to a message and closes
+// the channel once the handler completes.
+func TestHTTPClientSend(t *testing.T) {
+	assert := assert.New(t)
+	v, server, _ := newServer(nil)
+	defer server.Close()
+	v.AddHandler("count", func(ctx context.Context, req *vessel.Request, results chan<- string) {
+		results <- "1"
+		results <- "2"
+	})
+	client := NewHTTPClient(server.URL+"/vessel", nil)
+	defer client.Close()
+
+	responses, err := client.Send(context.Background(), "count", "")
+	if !assert.Nil(err) {
+		return
+	}
+
+	assert.Equal("1", receive(t, responses).Body)
+	assert.Equal("2", receive(t, responses).Body)
+	_, ok := <-responses
+	assert.False(ok)
+}
+
+// Ensures that the HTTP client returns an error when the server rejects a
+// message.
+func TestHTTPClientSendRejected(t *testing.T) {
+	_, server, _ := newServer(nil)
+	defer server.Close()
+	client := NewHTTPClient(server.URL+"/vessel", nil)
+	defer client.Close()
+
+	_, err := client.Send(context.Background(), "missing", "")
+
+	if assert.IsType(t, &statusError{}, err) {
+		assert.Equal(t, http.StatusInternalServerError, err.(*statusError).status)
+	}
+}
+
+// Ensures that the HTTP client receives broadcasts on channels it's
+// subscribed to.
+func TestHTTPClientSubscribe(t *testing.T) {
+	assert := assert.New(t)
+	v := vessel.NewSockJSVesselWithOptions("/vessel", &vessel.Options{
+		Persister: vessel.NewMemoryPersister(0),
+	})
+	handler := v.Handler()
+	polling := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") != "" {
+			select {
+			case polling <- struct{}{}:
+			default:
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client := NewHTTPClient(server.URL+"/vessel", nil)
+	defer client.Close()
+
+	messages, err := client.Subscribe("news")
+	if !assert.Nil(err) {
+		return
+	}
+	<-polling
+	v.Broadcast("news", "hello")
+
+	msg := receive(t, messages)
+	assert.Equal("news", msg.Channel)
+	assert.Equal("hello", msg.Body)
+
+	assert.Nil(client.Unsubscribe("news"))
+	_, ok := <-messages
+	assert.False(ok)
+}
+
+// Ensures that the HTTP client waits for new messages after receiving one
+// rather than polling for the same message again.
+func TestHTTPClientSubscribeWaits(t *testing.T) {
+	assert := assert.New(t)
+	v := vessel.NewSockJSVesselWithOptions("/vessel", &vessel.Options{
+		Persister: vessel.NewMemoryPersister(0),
+	})
+	handler := v.Handler()
+	var polls int32
+	polling := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") != "" {
+			atomic.AddInt32(&polls, 1)
+			select {
+			case polling <- struct{}{}:
+			default:
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client := NewHTTPClient(server.URL+"/vessel", nil)
+	defer client.Close()
+
+	messages, err := client.Subscribe("news")
+	if !assert.Nil(err) {
+		return
+	}
+	<-polling
+	v.Broadcast("news", "hello")
+	receive(t, messages)
+	v.Broadcast("news", "world")
+	assert.Equal("world", receive(t, messages).Body)
+	time.Sleep(200 * time.Millisecond)
+
+	assert.True(atomic.LoadInt32(&polls) <= 4, "Polled %d times", atomic.LoadInt32(&polls))
+}
@@ -0,0 +1,363 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tylertreat/vessel/vessel"
+)
+
+const (
+	// subscribeChannel and unsubscribeChannel are the reserved channels the
+	// server handles subscription requests on.
+	subscribeChannel   = "_subscribe"
+	unsubscribeChannel = "_unsubscribe"
+
+	// doneChannel is the reserved channel the client asks the server on to
+	// be told when handlers complete, which the server then tells it on.
+	doneChannel = "_done"
+
+	// presenceChannel is the reserved channel the server sends presence
+	// events on to the subscribers of the channel named in the event.
+	presenceChannel = "_presence"
+
+	// websocketPath is where the server accepts native WebSocket connections,
+	// relative to the vessel's URI.
+	websocketPath = "/ws"
+)
+
+var (
+	// ErrClosed is returned when using a Client which has been closed.
+	ErrClosed = errors.New("Client closed")
+
+	// ErrNotConnected is returned when sending while the WebSocket client is
+	// reconnecting.
+	ErrNotConnected = errors.New("Not connected")
+)
+
+// subscription is a channel the WebSocket client is subscribed to.
+type subscription struct {
+	mailbox *mailbox
+	tracker *tracker
+}
+
+type websocketClient struct {
+	url           string
+	opts          Options
+	conn          *websocket.Conn
+	calls         map[string]*mailbox
+	subscriptions map[string]*subscription
+	closed        bool
+	done          chan struct{}
+	mu            sync.Mutex
+	writeMu       sync.Mutex
+}
+
+// Dial returns a Client connected to the vessel at the URL over a native
+// WebSocket. The URL is the vessel's URI on the server's socket port, e.g.
+// "ws://localhost:8081/vessel". Nil Options use the defaults.
+func Dial(url string, options *Options) (Client, error) {
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	opts.setDefaults()
+
+	c := &websocketClient{
+		url:           strings.TrimSuffix(url, "/") + websocketPath,
+		opts:          opts,
+		calls:         map[string]*mailbox{},
+		subscriptions: map[string]*subscription{},
+		done:          make(chan struct{}),
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.read(conn)
+	return c, nil
+}
+
+// Send sends the body on the channel and returns a channel which receives the
+// handler's responses. The channel is closed once the server reports the
+// handler has completed, when the Context is done, when the connection is
+// lost or when the Client is closed. Servers which don't report completion
+// leave it open until one of the others, so give the Context a deadline.
+func (c *websocketClient) Send(ctx context.Context, channel, body string) (<-chan *vessel.Message, error) {
+	msg := &vessel.Message{
+		ID:        c.opts.IDGenerator(),
+		Channel:   channel,
+		Body:      body,
+		Timestamp: time.Now().Unix(),
+	}
+	responses := newMailbox()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	conn := c.conn
+	if conn == nil {
+		c.mu.Unlock()
+		return nil, ErrNotConnected
+	}
+	c.calls[msg.ID] = responses
+	c.mu.Unlock()
+
+	if err := c.write(conn, msg); err != nil {
+		c.removeCall(msg.ID, responses)
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-responses.closed:
+		}
+		c.removeCall(msg.ID, responses)
+	}()
+	return responses.out, nil
+}
+
+// Subscribe subscribes to the channel. If the connection is lost, the
+// subscription is renewed once reconnected and the messages missed in the
+// meantime are replayed.
+func (c *websocketClient) Subscribe(channel string) (<-chan *vessel.Message, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if _, ok := c.subscriptions[channel]; ok {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("Already subscribed to %s", channel)
+	}
+	sub := &subscription{mailbox: newMailbox(), tracker: newTracker()}
+	c.subscriptions[channel] = sub
+	conn := c.conn
+	c.mu.Unlock()
+
+	// If there's no connection, or it's lost while writing, the subscription
+	// is made once reconnected.
+	if conn != nil {
+		c.write(conn, c.control(subscribeChannel, channel, 0))
+	}
+	return sub.mailbox.out, nil
+}
+
+// Unsubscribe stops receiving broadcasts on the channel and closes its
+// channel.
+func (c *websocketClient) Unsubscribe(channel string) error {
+	c.mu.Lock()
+	sub, ok := c.subscriptions[channel]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("Not subscribed to %s", channel)
+	}
+	delete(c.subscriptions, channel)
+	conn := c.conn
+	c.mu.Unlock()
+
+	sub.mailbox.close()
+	if conn == nil {
+		return nil
+	}
+	return c.write(conn, c.control(unsubscribeChannel, channel, 0))
+}
+
+// Close disconnects the Client and closes the channels of messages in flight
+// and subscriptions.
+func (c *websocketClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	calls := c.calls
+	subscriptions := c.subscriptions
+	c.calls = map[string]*mailbox{}
+	c.subscriptions = map[string]*subscription{}
+	c.mu.Unlock()
+
+	for _, call := range calls {
+		call.close()
+	}
+	for _, sub := range subscriptions {
+		sub.mailbox.close()
+	}
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// dial connects to the server and asks to be told when handlers complete.
+func (c *websocketClient) dial() (*websocket.Conn, error) {
+	header := http.Header{}
+	if c.opts.Token != "" {
+		header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+	conn, _, err := c.opts.Dialer.Dial(c.url, header)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write(conn, c.control(doneChannel, "", 0)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *websocketClient) write(conn *websocket.Conn, msg *vessel.Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return conn.WriteJSON(msg)
+}
+
+// control returns a message for one of the server's reserved channels.
+func (c *websocketClient) control(channel, body string, timestamp int64) *vessel.Message {
+	return &vessel.Message{
+		ID:        c.opts.IDGenerator(),
+		Channel:   channel,
+		Body:      body,
+		Timestamp: timestamp,
+	}
+}
+
+// removeCall stops delivering responses to the mailbox and closes it.
+func (c *websocketClient) removeCall(id string, responses *mailbox) {
+	c.mu.Lock()
+	if c.calls[id] == responses {
+		delete(c.calls, id)
+	}
+	c.mu.Unlock()
+	responses.close()
+}
+
+// read delivers messages received on the connection, reconnecting whenever
+// it's lost, until the Client is closed.
+func (c *websocketClient) read(conn *websocket.Conn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			if conn = c.reconnect(); conn == nil {
+				return
+			}
+			continue
+		}
+
+		// Messages which can't be decoded can't be delivered anywhere.
+		var msg vessel.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		c.deliver(&msg)
+	}
+}
+
+// deliver routes the message to the call it responds to, finishing the call
+// if it reports the handler completed, or to the subscriptions to its channel
+// and the patterns matching it. Presence events go to the subscriptions to the
+// channel they describe.
+func (c *websocketClient) deliver(msg *vessel.Message) {
+	channel := msg.Channel
+	if channel == presenceChannel {
+		var event vessel.PresenceEvent
+		if err := json.Unmarshal([]byte(msg.Body), &event); err != nil {
+			return
+		}
+		channel = event.Channel
+	}
+
+	c.mu.Lock()
+	call := c.calls[msg.ID]
+	var subs []*subscription
+	if call == nil && channel != doneChannel {
+		for pattern, sub := range c.subscriptions {
+			if vessel.MatchChannel(pattern, channel) {
+				subs = append(subs, sub)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	switch {
+	case call != nil && msg.Channel == doneChannel:
+		call.finish()
+	case call != nil:
+		call.put(msg)
+	}
+	for _, sub := range subs {
+		if sub.tracker.add(msg) {
+			sub.mailbox.put(msg)
+		}
+	}
+}
+
+// reconnect closes the calls in flight on the lost connection, whose
+// responses won't arrive, and dials with increasing waits until it connects
+// or the Client is closed. Once connected, it renews subscriptions from the
+// last message received on them. It returns nil if the Client was closed.
+func (c *websocketClient) reconnect() *websocket.Conn {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.conn = nil
+	calls := c.calls
+	c.calls = map[string]*mailbox{}
+	c.mu.Unlock()
+
+	for _, call := range calls {
+		call.finish()
+	}
+
+	var wait time.Duration
+	for {
+		wait = c.opts.backoff(wait)
+		select {
+		case <-time.After(wait):
+		case <-c.done:
+			return nil
+		}
+
+		conn, err := c.dial()
+		if err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		c.conn = conn
+		subscriptions := make(map[string]*subscription, len(c.subscriptions))
+		for channel, sub := range c.subscriptions {
+			subscriptions[channel] = sub
+		}
+		c.mu.Unlock()
+
+		// If a write fails, the connection was lost again and the
+		// subscriptions are renewed on the next one.
+		for channel, sub := range subscriptions {
+			c.write(conn, c.control(subscribeChannel, channel, sub.tracker.since()))
+		}
+		return conn
+	}
+}
@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// conns records the connections hijacked by WebSocket upgrades so tests can
+// drop them.
+type conns struct {
+	conns []net.Conn
+	mu    sync.Mutex
+}
+
+func (c *conns) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.conns = nil
+}
+
+type hijackRecorder struct {
+	http.ResponseWriter
+	conns *conns
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.conns.mu.Lock()
+		h.conns.conns = append(h.conns.conns, conn)
+		h.conns.mu.Unlock()
+	}
+	return conn, rw, err
+}
+
+// newServer starts a vessel with an echo channel which responds with the
+// message body.
+func newServer(options *vessel.Options) (vessel.Vessel, *httptest.Server, *conns) {
+	if options == nil {
+		options = &vessel.Options{}
+	}
+	options.Persister = vessel.NewMemoryPersister(0)
+	v := vessel.NewSockJSVesselWithOptions("/vessel", options)
+	v.AddHandler("echo", func(ctx context.Context, req *vessel.Request, results chan<- string) {
+		results <- req.Body
+	})
+	recorded := &conns{}
+	handler := v.Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(&hijackRecorder{ResponseWriter: w, conns: recorded}, r)
+	}))
+	return v, server, recorded
+}
+
+func websocketURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/vessel"
+}
+
+func receive(t *testing.T, c <-chan *vessel.Message) *vessel.Message {
+	select {
+	case msg, ok := <-c:
+		if !ok {
+			t.Fatal("Channel closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out receiving message")
+	}
+	return nil
+}
+
+// echo sends a message and waits for its response. Messages are handled in
+// order, so anything sent before has been handled by the server.
+func echo(t *testing.T, client Client, body string) *vessel.Message {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	responses, err := client.Send(ctx, "echo", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receive(t, responses)
+}
+
+// Ensures that the WebSocket client receives the responses to messages it
+// sends.
+func TestWebsocketClientSend(t *testing.T) {
+	_, server, _ := newServer(nil)
+	defer server.Close()
+	client, err := Dial(websocketURL(server), nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer client.Close()
+
+	msg := echo(t, client, "hello")
+
+	assert.Equal(t, "echo", msg.Channel)
+	assert.Equal(t, "hello", msg.Body)
+}
+
+// Ensures that the WebSocket client closes the responses channel once the
+// server reports the handler has completed.
+func TestWebsocketClientSendDone(t *testing.T) {
+	_, server, _ := newServer(nil)
+	defer server.Close()
+	client, err := Dial(websocketURL(server), nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer client.Close()
+
+	responses, err := client.Send(context.Background(), "echo", "hello")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "hello", receive(t, responses).Body)
+	select {
+	case _, ok := <-responses:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Responses weren't closed")
+	}
+}
+
+// Ensures that the WebSocket client receives broadcasts on channels it's
+// subscribed to until it unsubscribes.
+func TestWebsocketClientSubscribe(t *testing.T) {
+	assert := assert.New(t)
+	v, server, _ := newServer(nil)
+	defer server.Close()
+	client, err := Dial(websocketURL(server), nil)
+	if !assert.Nil(err) {
+		return
+	}
+	defer client.Close()
+
+	messages, err := client.Subscribe("news")
+	assert.Nil(err)
+	_, err = client.Subscribe("news")
+	assert.NotNil(err)
+	echo(t, client, "sync")
+
+	v.Broadcast("news", "hello")
+	msg := receive(t, messages)
+	assert.Equal("news", msg.Channel)
+	assert.Equal("hello", msg.Body)
+
+	assert.Nil(client.Unsubscribe("news"))
+	_, ok := <-messages
+	assert.False(ok)
+}
+
+// Ensures that the WebSocket client reconnects when its connection is lost
+// and receives the broadcasts it missed.
+func TestWebsocketClientReconnect(t *testing.T) {
+	assert := assert.New(t)
+	v, server, conns := newServer(nil)
+	defer server.Close()
+	client, err := Dial(websocketURL(server), &Options{ReconnectWait: 10 * time.Millisecond})
+	if !assert.Nil(err) {
+		return
+	}
+	defer client.Close()
+	messages, _ := client.Subscribe("news")
+	echo(t, client, "sync")
+	v.Broadcast("news", "first")
+	assert.Equal("first", receive(t, messages).Body)
+
+	conns.closeAll()
+	v.Broadcast("news", "missed")
+
+	assert.Equal("missed", receive(t, messages).Body)
+	select {
+	case msg := <-messages:
+		t.Fatalf("Unexpected message %s", msg.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Ensures that closing the WebSocket client closes subscriptions and rejects
+// further use.
+func TestWebsocketClientClose(t *testing.T) {
+	assert := assert.New(t)
+	_, server, _ := newServer(nil)
+	defer server.Close()
+	client, err := Dial(websocketURL(server), nil)
+	if !assert.Nil(err) {
+		return
+	}
+	messages, _ := client.Subscribe("news")
+
+	assert.Nil(client.Close())
+
+	_, ok := <-messages
+	assert.False(ok)
+	_, err = client.Send(context.Background(), "echo", "hello")
+	assert.Equal(ErrClosed, err)
+}
+
+// Ensures that the WebSocket client presents its token when connecting.
+func TestWebsocketClientToken(t *testing.T) {
+	authenticator := vessel.AuthenticatorFunc(func(token string) (*vessel.Principal, error) {
+		if token != "secret" {
+			return nil, vessel.ErrNotAuthorized
+		}
+		return &vessel.Principal{ID: "user"}, nil
+	})
+	_, server, _ := newServer(&vessel.Options{Authenticator: authenticator})
+	defer server.Close()
+
+	_, err := Dial(websocketURL(server), &Options{Token: "wrong"})
+	assert.NotNil(t, err)
+
+	client, err := Dial(websocketURL(server), &Options{Token: "secret"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer client.Close()
+	assert.Equal(t, "hello", echo(t, client, "hello").Body)
+}
+
+// Ensures that the WebSocket client delivers broadcasts matching pattern
+// subscriptions and presence events on the channels it's subscribed to.
+func TestWebsocketClientSubscribePattern(t *testing.T) {
+	assert := assert.New(t)
+	v, server, _ := newServer(&vessel.Options{Presence: &vessel.PresenceConfig{Broadcast: true}})
+	defer server.Close()
+	client, err := Dial(websocketURL(server), nil)
+	if !assert.Nil(err) {
+		return
+	}
+	defer client.Close()
+	messages, _ := client.Subscribe("news.*")
+	presence, _ := client.Subscribe("sports")
+	echo(t, client, "sync")
+
+	v.Broadcast("news.eu", "hello")
+	msg := receive(t, messages)
+	assert.Equal("news.eu", msg.Channel)
+	assert.Equal("hello", msg.Body)
+
+	other, err := Dial(websocketURL(server), nil)
+	if !assert.Nil(err) {
+		return
+	}
+	defer other.Close()
+	other.Subscribe("sports")
+	msg = receive(t, presence)
+	var event vessel.PresenceEvent
+	assert.Equal(presenceChannel, msg.Channel)
+	if assert.Nil(json.Unmarshal([]byte(msg.Body), &event)) {
+		assert.Equal("sports", event.Channel)
+		assert.Equal(vessel.PresenceJoin, event.Type)
+	}
+}
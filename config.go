@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// config configures the serve command. It's read from a JSON file, then
+// overridden by environment variables and finally by flags.
+type config struct {
+	URI             string                    `json:"uri"`
+	SockAddr        string                    `json:"sockAddr"`
+	HTTPAddr        string                    `json:"httpAddr"`
+	LogLevel        string                    `json:"logLevel"`
+	ShutdownTimeout duration                  `json:"shutdownTimeout"`
+	HandlerTimeout  duration                  `json:"handlerTimeout"`
+	TLS             tlsConfig                 `json:"tls"`
+	Persister       persisterConfig           `json:"persister"`
+	Backplane       backplaneConfig           `json:"backplane"`
+	Retention       retentionConfig           `json:"retention"`
+	Presence        *presenceConfig           `json:"presence"`
+	CORS            corsConfig                `json:"cors"`
+	Auth            *authConfig               `json:"auth"`
+	Channels        map[string]*channelConfig `json:"channels"`
+}
+
+type tlsConfig struct {
+	CertFile     string `json:"certFile"`
+	KeyFile      string `json:"keyFile"`
+	ClientCAFile string `json:"clientCAFile"`
+}
+
+type persisterConfig struct {
+	// Type is "redis" or "memory".
+	Type     string `json:"type"`
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+// backplaneConfig relays broadcasts between nodes. Its Redis connection
+// defaults to the persister's.
+type backplaneConfig struct {
+	// Type is "redis", or empty to run a single node.
+	Type     string `json:"type"`
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	Channel  string `json:"channel"`
+}
+
+type retentionConfig struct {
+	ResultTTL   duration `json:"resultTTL"`
+	MessageTTL  duration `json:"messageTTL"`
+	MaxMessages int      `json:"maxMessages"`
+}
+
+// presenceConfig enables presence tracking when present.
+type presenceConfig struct {
+	Broadcast bool `json:"broadcast"`
+}
+
+// corsConfig allows cross-origin requests. AllowCredentials is a pointer so
+// that environment variables and flags can turn off credentials enabled by
+// the file.
+type corsConfig struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowCredentials *bool    `json:"allowCredentials"`
+}
+
+// authConfig authenticates clients with a fixed set of tokens and authorizes
+// them with rules.
+type authConfig struct {
+	Tokens map[string]*principalConfig `json:"tokens"`
+	Rules  []*ruleConfig               `json:"rules"`
+}
+
+type principalConfig struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+type ruleConfig struct {
+	Pattern string   `json:"pattern"`
+	Actions []string `json:"actions"`
+	Roles   []string `json:"roles"`
+}
+
+// duration is a time.Duration written as a string such as "10s" in
+// configuration files and flags.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("Invalid duration %s", data)
+	}
+	return d.Set(s)
+}
+
+// Set parses the duration, implementing flag.Value.
+func (d *duration) Set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("Invalid duration %s", s)
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d *duration) String() string {
+	return time.Duration(*d).String()
+}
+
+// optionalBool is a flag.Value setting a bool which is nil until given.
+type optionalBool struct {
+	b **bool
+}
+
+// Set parses the bool, implementing flag.Value.
+func (o optionalBool) Set(s string) error {
+	parsed, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("Invalid bool %s", s)
+	}
+	*o.b = &parsed
+	return nil
+}
+
+func (o optionalBool) String() string {
+	if o.b == nil || *o.b == nil {
+		return ""
+	}
+	return strconv.FormatBool(**o.b)
+}
+
+// IsBoolFlag allows the flag to be given without a value to set it to true.
+func (o optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+// stringList is a comma-separated list of strings in environment variables
+// and flags.
+type stringList []string
+
+// Set parses the list, implementing flag.Value.
+func (l *stringList) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func defaultConfig() *config {
+	return &config{
+		URI:             "/vessel",
+		SockAddr:        ":8081",
+		HTTPAddr:        ":8082",
+		LogLevel:        "info",
+		ShutdownTimeout: duration(10 * time.Second),
+		Persister:       persisterConfig{Type: "redis"},
+	}
+}
+
+// loadConfig returns the default configuration overridden by the JSON file at
+// the path, if any, and then by environment variables.
+func loadConfig(path string, getenv func(string) string) (*config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		file, err := readConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.merge(file)
+	}
+
+	env, err := envConfig(getenv)
+	if err != nil {
+		return nil, err
+	}
+	cfg.merge(env)
+	return cfg, nil
+}
+
+func readConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("Invalid config %s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// envConfig returns the configuration set by VESSEL_* environment variables.
+func envConfig(getenv func(string) string) (*config, error) {
+	cfg := &config{
+		URI:      getenv("VESSEL_URI"),
+		SockAddr: getenv("VESSEL_SOCK_ADDR"),
+		HTTPAddr: getenv("VESSEL_HTTP_ADDR"),
+		LogLevel: getenv("VESSEL_LOG_LEVEL"),
+		TLS: tlsConfig{
+			CertFile:     getenv("VESSEL_TLS_CERT_FILE"),
+			KeyFile:      getenv("VESSEL_TLS_KEY_FILE"),
+			ClientCAFile: getenv("VESSEL_TLS_CLIENT_CA_FILE"),
+		},
+		Persister: persisterConfig{
+			Type:     getenv("VESSEL_PERSISTER"),
+			Addr:     getenv("VESSEL_REDIS_ADDR"),
+			Password: getenv("VESSEL_REDIS_PASSWORD"),
+		},
+		Backplane: backplaneConfig{
+			Type:    getenv("VESSEL_BACKPLANE"),
+			Addr:    getenv("VESSEL_BACKPLANE_ADDR"),
+			Channel: getenv("VESSEL_BACKPLANE_CHANNEL"),
+		},
+	}
+	if db := getenv("VESSEL_REDIS_DB"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid VESSEL_REDIS_DB %s", db)
+		}
+		cfg.Persister.DB = n
+	}
+	if timeout := getenv("VESSEL_SHUTDOWN_TIMEOUT"); timeout != "" {
+		if err := cfg.ShutdownTimeout.Set(timeout); err != nil {
+			return nil, err
+		}
+	}
+	if origins := getenv("VESSEL_CORS_ORIGINS"); origins != "" {
+		var list stringList
+		list.Set(origins)
+		cfg.CORS.AllowedOrigins = list
+	}
+	if credentials := getenv("VESSEL_CORS_ALLOW_CREDENTIALS"); credentials != "" {
+		if err := (optionalBool{&cfg.CORS.AllowCredentials}).Set(credentials); err != nil {
+			return nil, fmt.Errorf("Invalid VESSEL_CORS_ALLOW_CREDENTIALS %s", credentials)
+		}
+	}
+	return cfg, nil
+}
+
+// merge overrides the configuration with the fields set in other.
+func (c *config) merge(other *config) {
+	mergeString(&c.URI, other.URI)
+	mergeString(&c.SockAddr, other.SockAddr)
+	mergeString(&c.HTTPAddr, other.HTTPAddr)
+	mergeString(&c.LogLevel, other.LogLevel)
+	if other.ShutdownTimeout != 0 {
+		c.ShutdownTimeout = other.ShutdownTimeout
+	}
+	if other.HandlerTimeout != 0 {
+		c.HandlerTimeout = other.HandlerTimeout
+	}
+
+	mergeString(&c.TLS.CertFile, other.TLS.CertFile)
+	mergeString(&c.TLS.KeyFile, other.TLS.KeyFile)
+	mergeString(&c.TLS.ClientCAFile, other.TLS.ClientCAFile)
+
+	mergeString(&c.Persister.Type, other.Persister.Type)
+	mergeString(&c.Persister.Addr, other.Persister.Addr)
+	mergeString(&c.Persister.Password, other.Persister.Password)
+	if other.Persister.DB != 0 {
+		c.Persister.DB = other.Persister.DB
+	}
+
+	mergeString(&c.Backplane.Type, other.Backplane.Type)
+	mergeString(&c.Backplane.Addr, other.Backplane.Addr)
+	mergeString(&c.Backplane.Password, other.Backplane.Password)
+	mergeString(&c.Backplane.Channel, other.Backplane.Channel)
+	if other.Backplane.DB != 0 {
+		c.Backplane.DB = other.Backplane.DB
+	}
+
+	if other.Retention != (retentionConfig{}) {
+		c.Retention = other.Retention
+	}
+	if other.Presence != nil {
+		c.Presence = other.Presence
+	}
+	if len(other.CORS.AllowedOrigins) > 0 {
+		c.CORS.AllowedOrigins = other.CORS.AllowedOrigins
+	}
+	if other.CORS.AllowCredentials != nil {
+		c.CORS.AllowCredentials = other.CORS.AllowCredentials
+	}
+	if other.Auth != nil {
+		c.Auth = other.Auth
+	}
+	if other.Channels != nil {
+		c.Channels = other.Channels
+	}
+}
+
+func mergeString(s *string, other string) {
+	if other != "" {
+		*s = other
+	}
+}
+
+// options returns the vessel Options described by the configuration.
+func (c *config) options() (*vessel.Options, error) {
+	level, err := vessel.ParseLogLevel(c.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	opts := &vessel.Options{
+		LogLevel:       level,
+		HandlerTimeout: time.Duration(c.HandlerTimeout),
+	}
+
+	switch c.Persister.Type {
+	case "", "redis":
+		opts.Redis = &vessel.RedisConfig{
+			Addr:     c.Persister.Addr,
+			Password: c.Persister.Password,
+			DB:       c.Persister.DB,
+		}
+	case "memory":
+		opts.Persister = vessel.NewMemoryPersister(c.Retention.MaxMessages)
+	default:
+		return nil, fmt.Errorf("Unknown persister %s", c.Persister.Type)
+	}
+
+	switch c.Backplane.Type {
+	case "":
+	case "redis":
+		redis := &vessel.RedisConfig{
+			Addr:     c.Backplane.Addr,
+			Password: c.Backplane.Password,
+			DB:       c.Backplane.DB,
+		}
+		if redis.Addr == "" {
+			redis.Addr = c.Persister.Addr
+			redis.Password = c.Persister.Password
+			redis.DB = c.Persister.DB
+		}
+		opts.Backplane = vessel.NewRedisBackplane(redis, c.Backplane.Channel)
+	default:
+		return nil, fmt.Errorf("Unknown backplane %s", c.Backplane.Type)
+	}
+
+	if c.Retention != (retentionConfig{}) {
+		opts.Retention = &vessel.RetentionPolicy{
+			ResultTTL:   time.Duration(c.Retention.ResultTTL),
+			MessageTTL:  time.Duration(c.Retention.MessageTTL),
+			MaxMessages: c.Retention.MaxMessages,
+		}
+	}
+
+	if c.Presence != nil {
+		opts.Presence = &vessel.PresenceConfig{Broadcast: c.Presence.Broadcast}
+	}
+
+	if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+		opts.TLS = &vessel.TLSConfig{
+			CertFile:     c.TLS.CertFile,
+			KeyFile:      c.TLS.KeyFile,
+			ClientCAFile: c.TLS.ClientCAFile,
+		}
+	}
+
+	if len(c.CORS.AllowedOrigins) > 0 {
+		cors := vessel.DefaultCORSConfig()
+		cors.AllowedOrigins = c.CORS.AllowedOrigins
+		cors.AllowCredentials = c.CORS.AllowCredentials != nil && *c.CORS.AllowCredentials
+		opts.CORS = cors
+	}
+
+	if c.Auth != nil {
+		if len(c.Auth.Tokens) > 0 {
+			opts.Authenticator = c.Auth.authenticator()
+		}
+		if len(c.Auth.Rules) > 0 {
+			rules, err := c.Auth.rules()
+			if err != nil {
+				return nil, err
+			}
+			opts.Authorizer = rules
+		}
+	}
+	return opts, nil
+}
+
+// authenticator returns an Authenticator which accepts the configured tokens.
+func (a *authConfig) authenticator() vessel.Authenticator {
+	return vessel.AuthenticatorFunc(func(token string) (*vessel.Principal, error) {
+		principal, ok := a.Tokens[token]
+		if !ok {
+			return nil, fmt.Errorf("Invalid token")
+		}
+		return &vessel.Principal{ID: principal.ID, Roles: principal.Roles}, nil
+	})
+}
+
+var actions = map[string]vessel.Action{
+	"send":      vessel.ActionSend,
+	"subscribe": vessel.ActionSubscribe,
+	"poll":      vessel.ActionPoll,
+}
+
+func (a *authConfig) rules() (vessel.Rules, error) {
+	rules := make(vessel.Rules, 0, len(a.Rules))
+	for _, r := range a.Rules {
+		rule := vessel.Rule{Pattern: r.Pattern, Roles: r.Roles}
+		for _, name := range r.Actions {
+			action, ok := actions[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown action %s", name)
+			}
+			rule.Actions = append(rule.Actions, action)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
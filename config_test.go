@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tylertreat/vessel/vessel"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "vessel.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+// Ensures that loadConfig returns the defaults without a file or environment.
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig("", env(nil))
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, defaultConfig(), cfg)
+	}
+}
+
+// Ensures that environment variables override the file, which overrides the
+// defaults.
+func TestLoadConfigPrecedence(t *testing.T) {
+	assert := assert.New(t)
+	path := writeConfig(t, `{
+		"uri": "/file",
+		"sockAddr": ":9001",
+		"shutdownTimeout": "30s",
+		"persister": {"type": "memory"},
+		"channels": {"echo": {"behavior": "echo"}}
+	}`)
+
+	cfg, err := loadConfig(path, env(map[string]string{
+		"VESSEL_URI":          "/env",
+		"VESSEL_CORS_ORIGINS": "https://a.example.com, https://b.example.com",
+	}))
+
+	if assert.Nil(err) {
+		assert.Equal("/env", cfg.URI)
+		assert.Equal(":9001", cfg.SockAddr)
+		assert.Equal(":8082", cfg.HTTPAddr)
+		assert.Equal(duration(30*time.Second), cfg.ShutdownTimeout)
+		assert.Equal("memory", cfg.Persister.Type)
+		assert.Equal([]string{"https://a.example.com", "https://b.example.com"}, cfg.CORS.AllowedOrigins)
+		assert.Equal("echo", cfg.Channels["echo"].Behavior)
+	}
+}
+
+// Ensures that environment variables and flags can turn off credentials
+// allowed by the file.
+func TestLoadConfigAllowCredentials(t *testing.T) {
+	assert := assert.New(t)
+	path := writeConfig(t, `{"cors": {"allowedOrigins": ["https://a.example.com"], "allowCredentials": true}}`)
+
+	cfg, err := loadConfig(path, env(nil))
+	if assert.Nil(err) {
+		opts, _ := cfg.options()
+		assert.True(opts.CORS.AllowCredentials)
+	}
+
+	cfg, err = loadConfig(path, env(map[string]string{"VESSEL_CORS_ALLOW_CREDENTIALS": "false"}))
+	if assert.Nil(err) {
+		opts, _ := cfg.options()
+		assert.False(opts.CORS.AllowCredentials)
+	}
+
+	set := &config{}
+	assert.Nil(optionalBool{&set.CORS.AllowCredentials}.Set("true"))
+	cfg.merge(set)
+	opts, _ := cfg.options()
+	assert.True(opts.CORS.AllowCredentials)
+}
+
+// Ensures that loadConfig returns an error for invalid files and variables.
+func TestLoadConfigInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := loadConfig(writeConfig(t, `{"shutdownTimeout": 10}`), env(nil))
+	assert.NotNil(err)
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.json"), env(nil))
+	assert.NotNil(err)
+	_, err = loadConfig("", env(map[string]string{"VESSEL_REDIS_DB": "one"}))
+	assert.NotNil(err)
+	_, err = loadConfig("", env(map[string]string{"VESSEL_CORS_ALLOW_CREDENTIALS": "maybe"}))
+	assert.NotNil(err)
+}
+
+// Ensures that options builds vessel Options from the configuration.
+func TestConfigOptions(t *testing.T) {
+	assert := assert.New(t)
+	cfg := defaultConfig()
+	cfg.LogLevel = "error"
+	cfg.Persister = persisterConfig{Type: "redis", Addr: "redis:6379", DB: 2}
+	cfg.TLS = tlsConfig{CertFile: "server.crt", KeyFile: "server.key"}
+	cfg.CORS = corsConfig{AllowedOrigins: []string{"*"}}
+	cfg.Presence = &presenceConfig{Broadcast: true}
+	cfg.Auth = &authConfig{
+		Tokens: map[string]*principalConfig{"secret": {ID: "alice", Roles: []string{"admin"}}},
+		Rules:  []*ruleConfig{{Pattern: "orders*", Actions: []string{"send"}, Roles: []string{"admin"}}},
+	}
+
+	opts, err := cfg.options()
+
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal(vessel.LogError, opts.LogLevel)
+	assert.Equal(&vessel.RedisConfig{Addr: "redis:6379", DB: 2}, opts.Redis)
+	assert.Nil(opts.Persister)
+	assert.Equal("server.crt", opts.TLS.CertFile)
+	assert.Equal([]string{"*"}, opts.CORS.AllowedOrigins)
+	assert.Nil(opts.Backplane)
+	assert.Equal(&vessel.PresenceConfig{Broadcast: true}, opts.Presence)
+
+	principal, err := opts.Authenticator.Authenticate("secret")
+	assert.Nil(err)
+	assert.Equal(&vessel.Principal{ID: "alice", Roles: []string{"admin"}}, principal)
+	_, err = opts.Authenticator.Authenticate("bogus")
+	assert.NotNil(err)
+
+	assert.True(opts.Authorizer.Authorize(principal, vessel.ActionSend, "orders.new"))
+	assert.False(opts.Authorizer.Authorize(principal, vessel.ActionSubscribe, "orders.new"))
+}
+
+// Ensures that options configures a Redis Backplane.
+func TestConfigOptionsBackplane(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Backplane.Type = "redis"
+
+	opts, err := cfg.options()
+
+	if assert.Nil(t, err) {
+		assert.NotNil(t, opts.Backplane)
+	}
+}
+
+// Ensures that options returns an error for unknown settings.
+func TestConfigOptionsInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := defaultConfig()
+	cfg.LogLevel = "verbose"
+	_, err := cfg.options()
+	assert.NotNil(err)
+
+	cfg = defaultConfig()
+	cfg.Persister.Type = "postgres"
+	_, err = cfg.options()
+	assert.NotNil(err)
+
+	cfg = defaultConfig()
+	cfg.Backplane.Type = "kafka"
+	_, err = cfg.options()
+	assert.NotNil(err)
+
+	cfg = defaultConfig()
+	cfg.Auth = &authConfig{Rules: []*ruleConfig{{Pattern: "*", Actions: []string{"delete"}}}}
+	_, err = cfg.options()
+	assert.NotNil(err)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// history prints the messages broadcast on a channel since a timestamp.
+func history(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("history", "channel", stderr)
+	vesselURL := flags.String("url", defaultHTTPURL, "vessel URL")
+	token := flags.String("token", "", "token to authenticate with")
+	since := flags.Int64("since", 0, "only print messages after this Unix timestamp")
+	asJSON := flags.Bool("json", false, "print messages as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	messages, err := fetchHistory(ctx, *vesselURL, *token, flags.Arg(0), *since)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for _, msg := range messages {
+		printMessage(stdout, msg, *asJSON)
+	}
+	return 0
+}
+
+// fetchHistory polls the channel's history from the vessel at the URL.
+func fetchHistory(ctx context.Context, vesselURL, token, channel string,
+	since int64) ([]*vessel.Message, error) {
+
+	u := fmt.Sprintf("%s/channel/%s?since=%s", strings.TrimSuffix(vesselURL, "/"),
+		url.PathEscape(channel), strconv.FormatInt(since, 10))
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	var messages []*vessel.Message
+	if err := json.Unmarshal(body, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
@@ -1,31 +1,117 @@
+// Command vessel sends, subscribes to and inspects channels on vessel servers
+// and runs a vessel server.
+//
+// Usage:
+//
+//	vessel send [flags] channel body
+//	vessel subscribe [flags] channel...
+//	vessel history [flags] channel
+//	vessel serve [flags]
 package main
 
 import (
-	"strconv"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/tylertreat/vessel/client"
 	"github.com/tylertreat/vessel/vessel"
 )
 
+const (
+	defaultHTTPURL      = "http://localhost:8082/vessel"
+	defaultWebsocketURL = "ws://localhost:8081/vessel"
+)
+
+// command runs a subcommand with its arguments, returning the exit status.
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string, stdout, stderr io.Writer) int
+}
+
+var commands = map[string]command{
+	"send":      {"Send a message to a channel and print its responses", send},
+	"subscribe": {"Print messages broadcast on channels until interrupted", subscribe},
+	"history":   {"Print the messages broadcast on a channel since a timestamp", history},
+	"serve":     {"Run a vessel server", serve},
+}
+
 func main() {
-	vessel := vessel.NewSockJSVessel("/vessel")
-
-	vessel.AddChannel("foo", func(msg string, c chan<- string, done chan<- bool) {
-		for x := 0; x < 10; x++ {
-			c <- strconv.Itoa(x)
-			time.Sleep(time.Second)
-		}
-		c <- "ping"
-		done <- true
-	})
-
-	go func() {
-		c := time.Tick(5 * time.Second)
-		for {
-			<-c
-			vessel.Broadcast("baz", "testing 123")
-		}
-	}()
-
-	vessel.Start(":8081", ":8082")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	status := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(status)
+}
+
+// run runs the subcommand named by the first argument.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command %s\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	return cmd.run(ctx, args[1:], stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: vessel <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'vessel <command> -h' for a command's flags.")
+}
+
+// newFlagSet returns a FlagSet for the subcommand which writes errors and
+// usage to stderr.
+func newFlagSet(name, arguments string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: vessel %s [flags] %s\n\nFlags:\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// newClient returns a Client for the URL, using a WebSocket for ws:// and
+// wss:// URLs and the HTTP polling API otherwise.
+func newClient(url, token string) (client.Client, error) {
+	opts := &client.Options{Token: token}
+	if strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://") {
+		return client.Dial(url, opts)
+	}
+	return client.NewHTTPClient(url, opts), nil
+}
+
+// printMessage writes the message as JSON or as a line with its time, channel
+// and body.
+func printMessage(w io.Writer, msg *vessel.Message, asJSON bool) {
+	if asJSON {
+		data, _ := json.Marshal(msg)
+		fmt.Fprintln(w, string(data))
+		return
+	}
+	timestamp := time.Unix(msg.Timestamp, 0).Format(time.RFC3339)
+	fmt.Fprintf(w, "%s %s %s\n", timestamp, msg.Channel, msg.Body)
 }
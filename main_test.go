@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// syncBuffer is a bytes.Buffer which may be written and read concurrently.
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestServer() (vessel.Vessel, *httptest.Server) {
+	v := vessel.NewSockJSVesselWithOptions("/vessel", &vessel.Options{
+		Persister: vessel.NewMemoryPersister(0),
+	})
+	v.AddHandler("echo", func(ctx context.Context, req *vessel.Request, results chan<- string) {
+		results <- req.Body
+	})
+	return v, httptest.NewServer(v.Handler())
+}
+
+// Ensures that run prints usage for unknown commands.
+func TestRunUnknownCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	status := run(context.Background(), []string{"bogus"}, &stdout, &stderr)
+
+	assert.Equal(t, 2, status)
+	assert.Contains(t, stderr.String(), "Unknown command bogus")
+	assert.Contains(t, stderr.String(), "subscribe")
+}
+
+// Ensures that send prints the responses to the message.
+func TestSend(t *testing.T) {
+	_, server := newTestServer()
+	defer server.Close()
+	var stdout, stderr bytes.Buffer
+
+	status := run(context.Background(), []string{"send", "-url", server.URL + "/vessel", "echo", "hello"},
+		&stdout, &stderr)
+
+	assert.Equal(t, 0, status, stderr.String())
+	assert.True(t, strings.HasSuffix(stdout.String(), " echo hello\n"))
+}
+
+// Ensures that send fails when the message is rejected.
+func TestSendRejected(t *testing.T) {
+	_, server := newTestServer()
+	defer server.Close()
+	var stdout, stderr bytes.Buffer
+
+	status := run(context.Background(), []string{"send", "-url", server.URL + "/vessel", "missing", "hello"},
+		&stdout, &stderr)
+
+	assert.Equal(t, 1, status)
+	assert.Contains(t, stderr.String(), "No channel registered for missing")
+}
+
+// Ensures that history prints the messages broadcast on the channel.
+func TestHistory(t *testing.T) {
+	v, server := newTestServer()
+	defer server.Close()
+	v.Broadcast("news", "first")
+	v.Broadcast("news", "second")
+	var stdout, stderr bytes.Buffer
+
+	status := run(context.Background(), []string{"history", "-url", server.URL + "/vessel", "-json", "news"},
+		&stdout, &stderr)
+
+	assert.Equal(t, 0, status, stderr.String())
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Contains(t, lines[0], `"body":"first"`)
+		assert.Contains(t, lines[1], `"body":"second"`)
+	}
+}
+
+// Ensures that subscribe prints broadcasts until interrupted.
+func TestSubscribe(t *testing.T) {
+	v, server := newTestServer()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	var stdout, stderr syncBuffer
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/vessel"
+	done := make(chan int)
+
+	go func() {
+		done <- run(ctx, []string{"subscribe", "-url", url, "news"}, &stdout, &stderr)
+	}()
+	// Broadcast until the subscription has been made.
+	for i := 0; i < 100 && !strings.Contains(stdout.String(), "hello"); i++ {
+		v.Broadcast("news", "hello")
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	assert.Equal(t, 0, <-done)
+	assert.Contains(t, stdout.String(), " news hello\n")
+}
+
+// Ensures that printMessage prints the message's time, channel and body.
+func TestPrintMessage(t *testing.T) {
+	var buf bytes.Buffer
+	msg := &vessel.Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412003438}
+
+	printMessage(&buf, msg, false)
+
+	expected := time.Unix(1412003438, 0).Format(time.RFC3339) + " foo bar\n"
+	assert.Equal(t, expected, buf.String())
+}
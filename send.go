@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// send sends a message to a channel and prints its responses until the
+// handler completes or the timeout elapses.
+func send(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("send", "channel body", stderr)
+	url := flags.String("url", defaultHTTPURL, "vessel URL; ws:// and wss:// URLs use a WebSocket")
+	token := flags.String("token", "", "token to authenticate with")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to wait for responses")
+	asJSON := flags.Bool("json", false, "print responses as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	c, err := newClient(*url, *token)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	responses, err := c.Send(ctx, flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for msg := range responses {
+		printMessage(stdout, msg, *asJSON)
+	}
+	return 0
+}
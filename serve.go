@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// serve runs a vessel server until interrupted, then shuts it down
+// gracefully. It's configured by a JSON file, VESSEL_* environment variables
+// and flags, in increasing order of precedence.
+func serve(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("serve", "", stderr)
+	configPath := flags.String("config", "", "JSON configuration file (default $VESSEL_CONFIG)")
+	set := &config{}
+	flags.StringVar(&set.URI, "uri", "", "URI to serve the vessel under (default \"/vessel\")")
+	flags.StringVar(&set.SockAddr, "sock", "", "address to serve SockJS and WebSockets on (default \":8081\")")
+	flags.StringVar(&set.HTTPAddr, "http", "", "address to serve the HTTP API on (default \":8082\")")
+	flags.StringVar(&set.LogLevel, "log-level", "", "debug, info, error or off (default \"info\")")
+	flags.StringVar(&set.Persister.Type, "persister", "", "redis or memory (default \"redis\")")
+	flags.StringVar(&set.Persister.Addr, "redis", "", "Redis address to persist to (default \":6379\")")
+	flags.StringVar(&set.Backplane.Type, "backplane", "", "redis to relay broadcasts between nodes")
+	memory := flags.Bool("memory", false, "persist in memory instead of Redis, same as -persister memory")
+	flags.StringVar(&set.TLS.CertFile, "tls-cert", "", "TLS certificate file")
+	flags.StringVar(&set.TLS.KeyFile, "tls-key", "", "TLS private key file")
+	flags.StringVar(&set.TLS.ClientCAFile, "tls-client-ca", "", "CA bundle to verify client certificates with")
+	flags.Var((*stringList)(&set.CORS.AllowedOrigins), "cors-origins",
+		"comma-separated origins allowed to make cross-origin requests")
+	flags.Var(optionalBool{&set.CORS.AllowCredentials}, "cors-allow-credentials",
+		"allow credentials in cross-origin requests from the allowed origins")
+	flags.Var(&set.ShutdownTimeout, "shutdown-timeout",
+		"how long to wait for handlers when shutting down (default 10s)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	if *memory {
+		set.Persister.Type = "memory"
+	}
+	if *configPath == "" {
+		*configPath = os.Getenv("VESSEL_CONFIG")
+	}
+
+	cfg, err := loadConfig(*configPath, os.Getenv)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	cfg.merge(set)
+
+	v, err := newServer(cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- v.Start(cfg.SockAddr, cfg.HTTPAddr)
+	}()
+	fmt.Fprintf(stdout, "Serving %s on %s and %s\n", cfg.URI, cfg.SockAddr, cfg.HTTPAddr)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	if err := v.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// newServer returns a Vessel configured by cfg with handlers for its
+// channels' behaviors.
+func newServer(cfg *config) (vessel.Vessel, error) {
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	v := vessel.NewSockJSVesselWithOptions(cfg.URI, opts)
+
+	names := make([]string, 0, len(cfg.Channels))
+	for name := range cfg.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		handler, err := newBehavior(cfg.Channels[name], v.Broadcast)
+		if err != nil {
+			return nil, fmt.Errorf("Channel %s: %s", name, err)
+		}
+		v.AddHandler(name, handler)
+	}
+	return v, nil
+}
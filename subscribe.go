@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/tylertreat/vessel/vessel"
+)
+
+// subscribe prints the messages broadcast on the channels until interrupted.
+func subscribe(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("subscribe", "channel...", stderr)
+	url := flags.String("url", defaultWebsocketURL, "vessel URL; http:// and https:// URLs use long polling")
+	token := flags.String("token", "", "token to authenticate with")
+	asJSON := flags.Bool("json", false, "print messages as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	c, err := newClient(*url, *token)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer c.Close()
+
+	// Merge the subscriptions, which only end if they're rejected.
+	merged := make(chan *vessel.Message)
+	var wg sync.WaitGroup
+	for _, channel := range flags.Args() {
+		messages, err := c.Subscribe(channel)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		wg.Add(1)
+		go func(messages <-chan *vessel.Message) {
+			defer wg.Done()
+			for msg := range messages {
+				select {
+				case merged <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(messages)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	for {
+		select {
+		case msg, ok := <-merged:
+			if !ok {
+				fmt.Fprintln(stderr, "Subscriptions ended")
+				return 1
+			}
+			printMessage(stdout, msg, *asJSON)
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package vessel
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingPrincipalTTL is how long a principal authenticated for a SockJS
+// session is held for the session to open and claim it.
+var pendingPrincipalTTL = 30 * time.Second
+
+const (
+	// authChannel is the reserved channel SockJS clients send on to
+	// authenticate with the token in the message body.
+	authChannel = "_auth"
+
+	// tokenParam is the query string parameter clients may pass their token
+	// in.
+	tokenParam = "token"
+
+	// unauthorizedStatus and unauthorizedReason are sent to sessions when
+	// they're closed for failing to authenticate.
+	unauthorizedStatus = 3001
+	unauthorizedReason = "Unauthorized"
+)
+
+// Principal identifies an authenticated client.
+type Principal struct {
+	// ID uniquely identifies the client, such as a user ID.
+	ID string
+
+	// Roles are the roles granted to the client.
+	Roles []string
+
+	// Metadata contains any additional information about the client.
+	Metadata map[string]string
+}
+
+// Authenticator verifies the token presented by a client.
+type Authenticator interface {
+	// Authenticate returns the Principal the token belongs to or an error if
+	// the token isn't valid.
+	Authenticate(string) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(string) (*Principal, error)
+
+// Authenticate calls f(token).
+func (f AuthenticatorFunc) Authenticate(token string) (*Principal, error) {
+	return f(token)
+}
+
+// requestToken returns the token presented by an HTTP request, either as a
+// bearer token in the Authorization header or in the query string. Requests
+// using another Authorization scheme don't present a token.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			return auth[len("Bearer "):]
+		}
+		return ""
+	}
+	return r.URL.Query().Get(tokenParam)
+}
+
+// sessionAuthenticator authenticates SockJS sessions which present a token in
+// the query string. SockJS doesn't expose the HTTP request to the session, so
+// principals are held by session ID until the session opens and claims them
+// or pendingPrincipalTTL passes.
+type sessionAuthenticator struct {
+	prefix        string
+	authenticator Authenticator
+	handler       http.Handler
+	pending       map[string]*pendingPrincipal
+	mu            sync.Mutex
+}
+
+// pendingPrincipal is a principal waiting to be claimed by a session.
+type pendingPrincipal struct {
+	principal *Principal
+	expires   time.Time
+}
+
+func newSessionAuthenticator(prefix string, authenticator Authenticator,
+	handler http.Handler) *sessionAuthenticator {
+
+	return &sessionAuthenticator{
+		prefix:        prefix,
+		authenticator: authenticator,
+		handler:       handler,
+		pending:       map[string]*pendingPrincipal{},
+	}
+}
+
+func (s *sessionAuthenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get(tokenParam)
+	sessionID := s.sessionID(r.URL.Path)
+	if token != "" && sessionID != "" {
+		principal, err := s.authenticator.Authenticate(token)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		s.hold(sessionID, principal)
+	}
+
+	s.handler.ServeHTTP(w, r)
+}
+
+// hold keeps the principal for the session until it's claimed or expires.
+// Expired principals, such as those of sessions which never opened or which
+// presented the token again after opening, are dropped.
+func (s *sessionAuthenticator) hold(sessionID string, principal *Principal) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, pending := range s.pending {
+		if now.After(pending.expires) {
+			delete(s.pending, id)
+		}
+	}
+	s.pending[sessionID] = &pendingPrincipal{principal: principal, expires: now.Add(pendingPrincipalTTL)}
+}
+
+// claim returns and forgets the principal authenticated for the session, if
+// any.
+func (s *sessionAuthenticator) claim(sessionID string) *Principal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending, ok := s.pending[sessionID]
+	if !ok {
+		return nil
+	}
+	delete(s.pending, sessionID)
+	if time.Now().After(pending.expires) {
+		return nil
+	}
+	return pending.principal
+}
+
+// sessionID returns the session ID from a SockJS transport URL of the form
+// prefix/{server}/{session}/{transport}, or an empty string if the path isn't
+// a transport URL.
+func (s *sessionAuthenticator) sessionID(path string) string {
+	if !strings.HasPrefix(path, s.prefix+"/") {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(path, s.prefix+"/"), "/")
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,103 @@
+package vessel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mockAuthenticator(token string) (*Principal, error) {
+	if token != "secret" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return &Principal{ID: "user"}, nil
+}
+
+// Ensures that requestToken reads bearer tokens and query parameters.
+func TestRequestToken(t *testing.T) {
+	assert := assert.New(t)
+
+	req, _ := http.NewRequest("GET", "http://example.com/vessel", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	assert.Equal("abc", requestToken(req))
+
+	req, _ = http.NewRequest("GET", "http://example.com/vessel?token=def", nil)
+	assert.Equal("def", requestToken(req))
+
+	req, _ = http.NewRequest("GET", "http://example.com/vessel", nil)
+	assert.Equal("", requestToken(req))
+
+	req, _ = http.NewRequest("GET", "http://example.com/vessel", nil)
+	req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	assert.Equal("", requestToken(req))
+}
+
+// Ensures that sessionAuthenticator holds the principal for a SockJS session
+// which presents a valid token until it's claimed.
+func TestSessionAuthenticator(t *testing.T) {
+	assert := assert.New(t)
+	called := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	s := newSessionAuthenticator("/vessel", AuthenticatorFunc(mockAuthenticator), handler)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/vessel/123/abc/xhr?token=secret", nil)
+
+	s.ServeHTTP(w, req)
+
+	assert.True(called)
+	if principal := s.claim("abc"); assert.NotNil(principal) {
+		assert.Equal("user", principal.ID)
+	}
+	assert.Nil(s.claim("abc"))
+}
+
+// Ensures that sessionAuthenticator drops principals which aren't claimed in
+// time.
+func TestSessionAuthenticatorExpires(t *testing.T) {
+	assert := assert.New(t)
+	ttl := pendingPrincipalTTL
+	pendingPrincipalTTL = -time.Second
+	defer func() { pendingPrincipalTTL = ttl }()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	s := newSessionAuthenticator("/vessel", AuthenticatorFunc(mockAuthenticator), handler)
+
+	for _, id := range []string{"abc", "def"} {
+		req, _ := http.NewRequest("POST", "http://example.com/vessel/123/"+id+"/xhr?token=secret", nil)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Len(s.pending, 1)
+	assert.Nil(s.claim("def"))
+}
+
+// Ensures that sessionAuthenticator rejects invalid tokens.
+func TestSessionAuthenticatorInvalidToken(t *testing.T) {
+	assert := assert.New(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected call to handler")
+	})
+	s := newSessionAuthenticator("/vessel", AuthenticatorFunc(mockAuthenticator), handler)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/vessel/123/abc/xhr?token=bad", nil)
+
+	s.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Nil(s.claim("abc"))
+}
+
+// Ensures that sessionID only matches SockJS transport URLs.
+func TestSessionAuthenticatorSessionID(t *testing.T) {
+	assert := assert.New(t)
+	s := newSessionAuthenticator("/vessel", AuthenticatorFunc(mockAuthenticator), nil)
+
+	assert.Equal("abc", s.sessionID("/vessel/123/abc/websocket"))
+	assert.Equal("", s.sessionID("/vessel/info"))
+	assert.Equal("", s.sessionID("/other/123/abc/xhr"))
+}
@@ -0,0 +1,123 @@
+package vessel
+
+import (
+	"errors"
+	"path"
+)
+
+// ErrNotAuthorized is returned when a client isn't allowed to perform an
+// action on a channel.
+var ErrNotAuthorized = errors.New("Not authorized")
+
+// Action is an operation a client performs on a channel.
+type Action int
+
+const (
+	// ActionSend is sending a message to the channel's handler.
+	ActionSend Action = iota
+
+	// ActionSubscribe is receiving messages broadcast on the channel.
+	ActionSubscribe
+
+	// ActionPoll is polling the channel's history or the responses to a
+	// message sent on it over HTTP.
+	ActionPoll
+)
+
+// Authorizer decides whether a client may perform an action on a channel.
+type Authorizer interface {
+	// Authorize returns true if the Principal may perform the Action on the
+	// channel. The Principal is nil for unauthenticated clients.
+	Authorize(*Principal, Action, string) bool
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(*Principal, Action, string) bool
+
+// Authorize calls f(principal, action, channel).
+func (f AuthorizerFunc) Authorize(principal *Principal, action Action, channel string) bool {
+	return f(principal, action, channel)
+}
+
+// Rule grants roles permission to perform actions on the channels matching a
+// pattern.
+type Rule struct {
+	// Pattern matches channel names using path.Match syntax, e.g. "orders*".
+	Pattern string
+
+	// Actions are the actions the rule allows. An empty list allows all
+	// actions.
+	Actions []Action
+
+	// Roles are the roles the rule applies to. An empty list applies to every
+	// client, including unauthenticated ones.
+	Roles []string
+}
+
+// allows indicates if the rule permits the Principal to perform the Action on
+// the channel.
+func (r Rule) allows(principal *Principal, action Action, channel string) bool {
+	if ok, err := path.Match(r.Pattern, channel); err != nil || !ok {
+		return false
+	}
+
+	if len(r.Actions) > 0 && !containsAction(r.Actions, action) {
+		return false
+	}
+
+	if len(r.Roles) == 0 {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	for _, role := range principal.Roles {
+		for _, allowed := range r.Roles {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Rules is an Authorizer which allows an action if any of its rules permit
+// it. Actions which no rule permits are denied.
+type Rules []Rule
+
+// Authorize returns true if any rule permits the Principal to perform the
+// Action on the channel.
+func (r Rules) Authorize(principal *Principal, action Action, channel string) bool {
+	for _, rule := range r {
+		if rule.allows(principal, action, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAction(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizedMessages returns the messages on channels the Principal may
+// perform the Action on. Everything is allowed without an Authorizer.
+func authorizedMessages(authorizer Authorizer, principal *Principal, action Action,
+	messages []*Message) []*Message {
+
+	if authorizer == nil {
+		return messages
+	}
+	authorized := make([]*Message, 0, len(messages))
+	for _, msg := range messages {
+		if authorizer.Authorize(principal, action, msg.Channel) {
+			authorized = append(authorized, msg)
+		}
+	}
+	return authorized
+}
@@ -0,0 +1,35 @@
+package vessel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that Rules allow actions matching a rule's pattern, actions and
+// roles and deny everything else.
+func TestRulesAuthorize(t *testing.T) {
+	assert := assert.New(t)
+	rules := Rules{
+		{Pattern: "public*"},
+		{Pattern: "orders*", Actions: []Action{ActionSubscribe, ActionPoll}, Roles: []string{"viewer"}},
+		{Pattern: "orders*", Roles: []string{"admin"}},
+	}
+	admin := &Principal{ID: "a", Roles: []string{"admin"}}
+	viewer := &Principal{ID: "b", Roles: []string{"viewer"}}
+
+	assert.True(rules.Authorize(nil, ActionSend, "public.news"))
+	assert.False(rules.Authorize(nil, ActionSubscribe, "orders.eu"))
+	assert.True(rules.Authorize(viewer, ActionSubscribe, "orders.eu"))
+	assert.True(rules.Authorize(viewer, ActionPoll, "orders.eu"))
+	assert.False(rules.Authorize(viewer, ActionSend, "orders.eu"))
+	assert.True(rules.Authorize(admin, ActionSend, "orders.eu"))
+	assert.False(rules.Authorize(admin, ActionSend, "billing"))
+}
+
+// Ensures that rules with malformed patterns never match.
+func TestRulesAuthorizeBadPattern(t *testing.T) {
+	rules := Rules{{Pattern: "[orders"}}
+
+	assert.False(t, rules.Authorize(nil, ActionSend, "[orders"))
+}
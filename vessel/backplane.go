@@ -0,0 +1,172 @@
+package vessel
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	// recentIDsSize is how many message IDs are remembered to deduplicate
+	// messages relayed by the Backplane.
+	recentIDsSize = 10000
+
+	// envelopeBroadcast is the kind of envelope carrying a broadcast message.
+	envelopeBroadcast = "broadcast"
+)
+
+// Backplane relays messages between the nodes of a cluster so that clients
+// connected to any node receive broadcasts made on every node. Nodes running
+// behind a load balancer should share a Backplane and a Persister.
+type Backplane interface {
+	// Publish sends the data to every node subscribed to the Backplane,
+	// including this one.
+	Publish([]byte) error
+
+	// Subscribe calls the function with the data published by any node until
+	// the Backplane is closed. It's called once by the Vessel's Prepare.
+	Subscribe(func([]byte)) error
+
+	// Close stops delivering published data and releases the Backplane's
+	// resources. It's called when the Vessel shuts down.
+	Close() error
+}
+
+// envelope wraps a message relayed by the Backplane with what the receiving
+// nodes should do with it and the node which published it.
+type envelope struct {
+	Kind    string   `json:"kind"`
+	Node    string   `json:"node"`
+	Session string   `json:"session,omitempty"`
+	Owner   string   `json:"owner,omitempty"`
+	Channel string   `json:"channel,omitempty"`
+	ID      string   `json:"id,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// recentIDs remembers the most recently seen message IDs, forgetting the
+// oldest once it's full.
+type recentIDs struct {
+	ids   map[string]struct{}
+	order []string
+	next  int
+	mu    sync.Mutex
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: map[string]struct{}{}, order: make([]string, size)}
+}
+
+// add records the ID and returns false if it has already been seen.
+func (r *recentIDs) add(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+	if oldest := r.order[r.next]; oldest != "" {
+		delete(r.ids, oldest)
+	}
+	r.ids[id] = struct{}{}
+	r.order[r.next] = id
+	r.next = (r.next + 1) % len(r.order)
+	return true
+}
+
+// publish relays the envelope to the other nodes through the Backplane.
+func (s *sockjsVessel) publish(e *envelope) {
+	e.Node = s.node
+	data, err := json.Marshal(e)
+	if err != nil {
+		s.log.errorf("Failed to marshal %s envelope: %s", e.Kind, err)
+		return
+	}
+	if err := s.backplane.Publish(data); err != nil {
+		s.log.errorf("Failed to publish %s envelope: %s", e.Kind, err)
+	}
+}
+
+// receive handles data published to the Backplane by any node. Envelopes
+// published by this node have already been handled locally.
+func (s *sockjsVessel) receive(data []byte) {
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		s.log.errorf("Bad envelope from backplane: %s", data)
+		return
+	}
+	if e.Node == s.node {
+		return
+	}
+
+	switch e.Kind {
+	case envelopeBroadcast:
+		if e.Message != nil {
+			s.deliver(e.Message)
+		}
+	case envelopeResponse:
+		if e.Message != nil {
+			s.sendLocal(e.Session, e.Owner, e.Message)
+		}
+	case envelopeUser:
+		if e.Message != nil {
+			s.deliverToUser(e.Owner, e.Message)
+		}
+	case envelopePresence:
+		if e.Message != nil {
+			s.deliverPresence(e.Channel, e.Message)
+		}
+	case envelopeResult:
+		s.httpHandler.notifier.notify(resultKey(e.ID))
+	default:
+		s.log.infof("Unknown envelope kind %s", e.Kind)
+	}
+}
+
+// MemoryBus connects Vessels in the same process, such as in tests, as if
+// they were nodes sharing a Backplane.
+type MemoryBus struct {
+	subscribers map[*memoryBackplane]func([]byte)
+	mu          sync.RWMutex
+}
+
+// NewMemoryBus returns a new MemoryBus.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{subscribers: map[*memoryBackplane]func([]byte){}}
+}
+
+// Backplane returns a new Backplane on the bus for one Vessel.
+func (b *MemoryBus) Backplane() Backplane {
+	return &memoryBackplane{bus: b}
+}
+
+type memoryBackplane struct {
+	bus *MemoryBus
+}
+
+// Publish calls every subscriber on the bus with the data before returning.
+func (m *memoryBackplane) Publish(data []byte) error {
+	m.bus.mu.RLock()
+	handlers := make([]func([]byte), 0, len(m.bus.subscribers))
+	for _, handler := range m.bus.subscribers {
+		handlers = append(handlers, handler)
+	}
+	m.bus.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(data)
+	}
+	return nil
+}
+
+func (m *memoryBackplane) Subscribe(handler func([]byte)) error {
+	m.bus.mu.Lock()
+	defer m.bus.mu.Unlock()
+	m.bus.subscribers[m] = handler
+	return nil
+}
+
+func (m *memoryBackplane) Close() error {
+	m.bus.mu.Lock()
+	defer m.bus.mu.Unlock()
+	delete(m.bus.subscribers, m)
+	return nil
+}
@@ -0,0 +1,88 @@
+package vessel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockBackplane struct {
+	mock.Mock
+}
+
+func (m *mockBackplane) Publish(data []byte) error {
+	args := m.Mock.Called(data)
+	return args.Error(0)
+}
+
+func (m *mockBackplane) Subscribe(receive func([]byte)) error {
+	args := m.Mock.Called(receive)
+	return args.Error(0)
+}
+
+func (m *mockBackplane) Close() error {
+	args := m.Mock.Called()
+	return args.Error(0)
+}
+
+// newNode returns a Vessel on the bus with a session subscribed to the
+// channel.
+func newNode(t *testing.T, bus *MemoryBus, channel string) (*sockjsVessel, *mockSession) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{
+		Persister: NewMemoryPersister(0),
+		Backplane: bus.Backplane(),
+	}).(*sockjsVessel)
+	if err := v.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	mockSession := new(mockSession)
+	mockSession.On("ID").Return(newUUID())
+	mockSession.On("Send", mock.Anything).Return(nil)
+	session := newSession(context.Background(), mockSession)
+	session.subscribe(channel)
+	v.sessions.add(session)
+	return v, mockSession
+}
+
+// Ensures that recentIDs recognizes IDs it has seen and forgets the oldest
+// once full.
+func TestRecentIDs(t *testing.T) {
+	assert := assert.New(t)
+	recent := newRecentIDs(2)
+
+	assert.True(recent.add("a"))
+	assert.True(recent.add("b"))
+	assert.False(recent.add("a"))
+	assert.True(recent.add("c"))
+	assert.True(recent.add("a"))
+	assert.False(recent.add("c"))
+}
+
+// Ensures that broadcasts are delivered once to subscribers on every node
+// sharing a Backplane.
+func TestBroadcastBackplane(t *testing.T) {
+	bus := NewMemoryBus()
+	node1, session1 := newNode(t, bus, "foo")
+	_, session2 := newNode(t, bus, "foo")
+	_, session3 := newNode(t, bus, "bar")
+
+	node1.Broadcast("foo", "hello")
+
+	session1.AssertNumberOfCalls(t, "Send", 1)
+	session2.AssertNumberOfCalls(t, "Send", 1)
+	session3.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+// Ensures that closing a node's Backplane stops it receiving broadcasts.
+func TestMemoryBackplaneClose(t *testing.T) {
+	bus := NewMemoryBus()
+	node1, _ := newNode(t, bus, "foo")
+	node2, session2 := newNode(t, bus, "foo")
+
+	node2.backplane.Close()
+	node1.Broadcast("foo", "hello")
+
+	session2.AssertNotCalled(t, "Send", mock.Anything)
+}
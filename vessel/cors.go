@@ -0,0 +1,207 @@
+package vessel
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultCORSMethods = []string{"POST", "GET", "OPTIONS", "PUT", "DELETE"}
+	defaultCORSHeaders = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding",
+		"X-CSRF-Token", "Authorization"}
+)
+
+// CORSConfig configures which origins may make cross-origin requests to the
+// Vessel. Requests from the Vessel's own origin are always allowed.
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to make requests. Entries may
+	// use path.Match wildcards, e.g. "https://*.example.com", and "*" allows
+	// every origin. Origins only allowed by "*" are answered with a literal
+	// "*" and never with credentials.
+	AllowedOrigins []string
+
+	// AllowedMethods are the methods allowed in cross-origin requests.
+	// Defaults to POST, GET, OPTIONS, PUT and DELETE.
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers allowed in cross-origin
+	// requests. Defaults to the headers used by the vessel.js client.
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers browsers may read.
+	ExposedHeaders []string
+
+	// AllowCredentials allows browsers to send cookies and HTTP
+	// authentication with cross-origin requests from the Vessel's own origin
+	// and the origins listed in AllowedOrigins other than "*".
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache the result of a preflight
+	// request. Zero leaves it up to the browser.
+	MaxAge time.Duration
+}
+
+// DefaultCORSConfig returns the CORSConfig used when none is provided, which
+// allows every origin without credentials, SockJS included.
+func DefaultCORSConfig() *CORSConfig {
+	return &CORSConfig{AllowedOrigins: []string{"*"}}
+}
+
+// allowed indicates if the request's origin may access the Vessel. Requests
+// without an Origin header and same-origin requests are always allowed.
+func (c *CORSConfig) allowed(r *http.Request) bool {
+	return r.Header.Get("Origin") == "" || c.trusted(r) || c.allowsAny()
+}
+
+// trusted indicates if the request comes from the Vessel's own origin or an
+// origin listed in AllowedOrigins other than "*".
+func (c *CORSConfig) trusted(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if sameOrigin(r, origin) {
+		return true
+	}
+	for _, pattern := range c.AllowedOrigins {
+		if pattern == "*" {
+			continue
+		}
+		if ok, err := path.Match(pattern, origin); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAny indicates if every origin is allowed.
+func (c *CORSConfig) allowsAny() bool {
+	for _, pattern := range c.AllowedOrigins {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin indicates if the origin has the request's scheme and host.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return u.Scheme == scheme && u.Host == r.Host
+}
+
+// setHeaders writes the CORS response headers for a request from an allowed
+// origin. Origins which are only allowed by "*" get a literal "*" without
+// credentials, since browsers refuse credentials with a wildcard and echoing
+// the origin would grant them to every site.
+func (c *CORSConfig) setHeaders(rw http.ResponseWriter, r *http.Request) {
+	methods := c.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := c.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	h := rw.Header()
+	trusted := c.trusted(r)
+	if trusted {
+		h.Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		h.Add("Vary", "Origin")
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if len(c.ExposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+	if c.AllowCredentials && trusted {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.FormatInt(int64(c.MaxAge/time.Second), 10))
+	}
+}
+
+// originGuard rejects requests from origins the CORSConfig doesn't allow
+// before they reach the wrapped handler. SockJS sets its own CORS headers,
+// reflecting any origin with credentials, so they're replaced with the
+// CORSConfig's when the response is written.
+type originGuard struct {
+	cors    *CORSConfig
+	handler http.Handler
+}
+
+func (o *originGuard) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !o.cors.allowed(req) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+	o.handler.ServeHTTP(&corsWriter{ResponseWriter: rw, cors: o.cors, req: req}, req)
+}
+
+// corsWriter replaces the CORS headers set by the handler it's passed to with
+// the CORSConfig's before the response header is written. It supports the
+// flushing and hijacking SockJS's streaming and WebSocket transports need.
+type corsWriter struct {
+	http.ResponseWriter
+	cors        *CORSConfig
+	req         *http.Request
+	wroteHeader bool
+}
+
+func (w *corsWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		for key := range h {
+			if strings.HasPrefix(key, "Access-Control-") {
+				h.Del(key)
+			}
+		}
+		w.cors.setHeaders(w.ResponseWriter, w.req)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *corsWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *corsWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *corsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Hijacking not supported")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped ResponseWriter for http.ResponseController.
+func (w *corsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,155 @@
+package vessel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that allowed matches configured origins, wildcards and the
+// Vessel's own origin.
+func TestCORSConfigAllowed(t *testing.T) {
+	assert := assert.New(t)
+	cors := &CORSConfig{AllowedOrigins: []string{"https://app.example.com", "https://*.tenant.com"}}
+	request := func(origin string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://vessel.example.com/vessel", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		return req
+	}
+
+	assert.True(cors.allowed(request("")))
+	assert.True(cors.allowed(request("https://app.example.com")))
+	assert.True(cors.allowed(request("https://acme.tenant.com")))
+	assert.True(cors.allowed(request("http://vessel.example.com")))
+	assert.False(cors.allowed(request("https://vessel.example.com")))
+	assert.False(cors.allowed(request("https://evil.com")))
+	assert.True(DefaultCORSConfig().allowed(request("https://evil.com")))
+}
+
+// Ensures that httpServer writes the configured CORS headers for allowed
+// origins.
+func TestHTTPServerCORS(t *testing.T) {
+	assert := assert.New(t)
+	server := &httpServer{r: router(nil), cors: &CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		ExposedHeaders:   []string{"X-Vessel"},
+		AllowCredentials: true,
+		MaxAge:           time.Minute,
+	}}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/vessel", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+
+	server.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("X-Vessel", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal("true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal("60", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal("POST, GET, OPTIONS, PUT, DELETE", w.Header().Get("Access-Control-Allow-Methods"))
+}
+
+// Ensures that origins only allowed by "*" get a literal "*" without
+// credentials while listed origins have theirs echoed.
+func TestHTTPServerCORSWildcard(t *testing.T) {
+	assert := assert.New(t)
+	server := &httpServer{r: router(nil), cors: &CORSConfig{
+		AllowedOrigins:   []string{"*", "https://app.example.com"},
+		AllowCredentials: true,
+	}}
+	request := func(origin string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("OPTIONS", "http://example.com/vessel", nil)
+		req.Header.Set("Origin", origin)
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("https://evil.com")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	w = request("https://app.example.com")
+	assert.Equal("https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+// Ensures that httpServer rejects requests from origins which aren't allowed.
+func TestHTTPServerCORSForbidden(t *testing.T) {
+	assert := assert.New(t)
+	server := &httpServer{r: router(nil), cors: &CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+	}}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/vessel", nil)
+	req.Header.Set("Origin", "https://evil.com")
+
+	server.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusForbidden, w.Code)
+	assert.Equal("", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+// Ensures that originGuard only passes allowed origins to SockJS.
+func TestOriginGuard(t *testing.T) {
+	assert := assert.New(t)
+	guard := &originGuard{
+		cors: &CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/info", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	guard.ServeHTTP(w, req)
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Origin", "https://app.example.com")
+	guard.ServeHTTP(w, req)
+	assert.Equal(http.StatusTeapot, w.Code)
+}
+
+// Ensures that originGuard replaces the CORS headers SockJS sets with the
+// CORSConfig's.
+func TestOriginGuardHeaders(t *testing.T) {
+	assert := assert.New(t)
+	sockjs := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Max-Age", "31536000")
+		w.Write([]byte("{}"))
+	})
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/info", nil)
+	req.Header.Set("Origin", "https://evil.com")
+
+	w := httptest.NewRecorder()
+	(&originGuard{cors: DefaultCORSConfig(), handler: sockjs}).ServeHTTP(w, req)
+	assert.Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal("", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal("{}", w.Body.String())
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Origin", "https://app.example.com")
+	cors := &CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"X-Vessel"},
+		MaxAge:           time.Minute,
+	}
+	(&originGuard{cors: cors, handler: sockjs}).ServeHTTP(w, req)
+	assert.Equal("https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal("X-Vessel", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal("60", w.Header().Get("Access-Control-Max-Age"))
+}
@@ -0,0 +1,100 @@
+package vessel
+
+import "errors"
+
+// envelopeUser is the kind of envelope carrying a message for every session
+// of a user.
+const envelopeUser = "user"
+
+// ErrSessionNotFound is returned when sending to a session which isn't
+// connected.
+var ErrSessionNotFound = errors.New("Session not found")
+
+// SendToSession sends a message on the channel to the session with the ID. If
+// the session isn't connected to this node, the message is relayed to the
+// others through the Backplane and dropped if it isn't connected anywhere.
+// Without a Backplane, ErrSessionNotFound is returned instead.
+func (s *sockjsVessel) SendToSession(sessionID, channel, body string) error {
+	msg := s.messageGenerator(s.idGenerator(), channel, body)
+	if s.sendLocal(sessionID, "", msg) {
+		return nil
+	}
+	if s.backplane == nil {
+		return ErrSessionNotFound
+	}
+	s.publish(&envelope{Kind: envelopeResponse, Session: sessionID, Message: msg})
+	return nil
+}
+
+// SendToUser sends a message on the channel to every session authenticated as
+// the Principal with the ID, on every node. If the Persister is a
+// QueuePersister, the message is queued until it's delivered, so a user who
+// isn't connected receives it once they next connect and authenticate. A
+// message may be delivered twice if the user connects while it's being sent.
+func (s *sockjsVessel) SendToUser(principalID, channel, body string) error {
+	msg := s.messageGenerator(s.idGenerator(), channel, body)
+	if queue, ok := s.persister.(QueuePersister); ok {
+		if err := queue.QueueMessage(principalID, msg); err != nil {
+			return err
+		}
+	}
+
+	s.deliverToUser(principalID, msg)
+	if s.backplane != nil {
+		s.publish(&envelope{Kind: envelopeUser, Owner: principalID, Message: msg})
+	}
+	return nil
+}
+
+// deliverToUser sends the message to the user's sessions connected to this
+// node. Once it's been sent to any of them, it's removed from the user's
+// queue.
+func (s *sockjsVessel) deliverToUser(principalID string, msg *Message) {
+	sessions := s.sessions.forPrincipal(principalID)
+	if len(sessions) == 0 {
+		return
+	}
+
+	send, err := s.marshaler.Marshal(msg)
+	if err != nil {
+		s.log.errorf("Failed to marshal message %s: %s", msg.ID, err)
+		return
+	}
+	sendStr := string(send)
+	for _, session := range sessions {
+		s.log.debugf("Send %s", sendStr)
+		session.Send(sendStr)
+	}
+
+	queue, ok := s.persister.(QueuePersister)
+	if !ok {
+		return
+	}
+	if err := queue.DeleteQueued(principalID, msg.ID); err != nil {
+		s.log.errorf("Failed to dequeue message %s for %s: %s", msg.ID, principalID, err)
+	}
+}
+
+// deliverQueued sends the session the messages queued for its Principal while
+// the user wasn't connected.
+func (s *sockjsVessel) deliverQueued(session *session) {
+	principal := session.getPrincipal()
+	queue, ok := s.persister.(QueuePersister)
+	if principal == nil || !ok {
+		return
+	}
+
+	messages, err := queue.TakeQueued(principal.ID)
+	if err != nil {
+		s.log.errorf("Failed to get queued messages for %s: %s", principal.ID, err)
+		return
+	}
+	for _, msg := range messages {
+		send, err := s.marshaler.Marshal(msg)
+		if err != nil {
+			s.log.errorf("Failed to marshal message %s: %s", msg.ID, err)
+			continue
+		}
+		session.Send(string(send))
+	}
+}
@@ -0,0 +1,92 @@
+package vessel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Ensures that SendToSession sends to a session connected to the same node.
+func TestSendToSession(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	session := addMockSession(v, "s1", nil)
+	other := addMockSession(v, "s2", nil)
+
+	err := v.SendToSession("s1", "foo", "hello")
+
+	assert.Nil(t, err)
+	session.AssertNumberOfCalls(t, "Send", 1)
+	other.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+// Ensures that SendToSession returns ErrSessionNotFound when the session isn't
+// connected and there's no Backplane.
+func TestSendToSessionNotFound(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)})
+
+	assert.Equal(t, ErrSessionNotFound, v.SendToSession("s1", "foo", "hello"))
+}
+
+// Ensures that SendToSession relays messages to sessions on other nodes
+// through the Backplane.
+func TestSendToSessionBackplane(t *testing.T) {
+	bus := NewMemoryBus()
+	node1, _ := newNode(t, bus, "foo")
+	node2, _ := newNode(t, bus, "foo")
+	session := addMockSession(node2, "s1", nil)
+
+	err := node1.SendToSession("s1", "bar", "hello")
+
+	assert.Nil(t, err)
+	session.AssertNumberOfCalls(t, "Send", 1)
+}
+
+// Ensures that SendToUser sends to every session of the user on every node and
+// doesn't leave the message queued.
+func TestSendToUser(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewMemoryBus()
+	node1, _ := newNode(t, bus, "foo")
+	node2, _ := newNode(t, bus, "foo")
+	session1 := addMockSession(node1, "s1", &Principal{ID: "user"})
+	session2 := addMockSession(node2, "s2", &Principal{ID: "user"})
+	other := addMockSession(node2, "s3", &Principal{ID: "other"})
+
+	assert.Nil(node1.SendToUser("user", "foo", "hello"))
+
+	session1.AssertNumberOfCalls(t, "Send", 1)
+	session2.AssertNumberOfCalls(t, "Send", 1)
+	other.AssertNotCalled(t, "Send", mock.Anything)
+	queued, _ := node1.persister.(QueuePersister).TakeQueued("user")
+	assert.Equal(0, len(queued))
+}
+
+// Ensures that messages sent to a user who isn't connected are delivered once
+// they authenticate.
+func TestSendToUserOffline(t *testing.T) {
+	assert := assert.New(t)
+	v := NewSockJSVesselWithOptions("/vessel", &Options{
+		Persister:     NewMemoryPersister(0),
+		Authenticator: AuthenticatorFunc(mockAuthenticator),
+	}).(*sockjsVessel)
+
+	assert.Nil(v.SendToUser("user", "foo", "hello"))
+
+	received := []string{}
+	mockSession := new(mockSession)
+	mockSession.On("ID").Return("s1")
+	mockSession.On("Send", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		received = append(received, args.String(0))
+	})
+	session := newSession(context.Background(), mockSession)
+	v.sessions.add(session)
+	assert.True(v.control(session, &Message{ID: "abc", Channel: authChannel, Body: "secret"}))
+
+	if assert.Equal(1, len(received)) {
+		assert.Contains(received[0], `"channel":"foo","body":"hello"`)
+	}
+	queued, _ := v.persister.(QueuePersister).TakeQueued("user")
+	assert.Equal(0, len(queued))
+}
@@ -2,25 +2,44 @@ package vessel
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+const (
+	// maxPollWait is the longest a poll may wait for new responses or
+	// messages.
+	maxPollWait = time.Minute
+
+	// seenIDParam is the query string parameter channel polls name the
+	// messages they have already received with the since timestamp in.
+	seenIDParam = "seenId"
+)
+
 type httpServer struct {
-	r *mux.Router
+	r    *mux.Router
+	cors *CORSConfig
 }
 
 func (h *httpServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	if origin := req.Header.Get("Origin"); origin != "" {
-		rw.Header().Set("Access-Control-Allow-Origin", origin)
-		rw.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		rw.Header().Set("Access-Control-Allow-Headers",
-			"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+	cors := h.cors
+	if cors == nil {
+		cors = DefaultCORSConfig()
+	}
+
+	if !cors.allowed(req) {
+		rw.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if req.Header.Get("Origin") != "" {
+		cors.setHeaders(rw, req)
 	}
 
 	if req.Method == "OPTIONS" {
@@ -30,56 +49,117 @@ func (h *httpServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	h.r.ServeHTTP(rw, req)
 }
 
-type result struct {
+// Result contains the responses produced by a Channel handler for a message
+// and whether the handler has completed.
+type Result struct {
+	Channel   string     `json:"channel,omitempty"`
 	Done      bool       `json:"done"`
-	Responses []*message `json:"responses"`
+	Responses []*Message `json:"responses"`
 }
 
 type httpHandler struct {
 	Vessel
-	marshaler *jsonMarshaler
+	marshaler     Marshaler
+	authenticator Authenticator
+	authorizer    Authorizer
+	notifier      *notifier
+	router        sessionRouter
+	log           *logger
+	stop          <-chan struct{}
+	wg            sync.WaitGroup
+	closing       bool
+	mu            sync.Mutex
 }
 
 func newHTTPHandler(vessel Vessel) *httpHandler {
 	return &httpHandler{
-		vessel,
-		&jsonMarshaler{},
+		Vessel:    vessel,
+		marshaler: &jsonMarshaler{},
+		notifier:  newNotifier(),
+		log:       newLogger(LogDebug, nil),
 	}
 }
 
 // send allows HTTP clients to send messages into the system. It calls Recv on
 // messages to invoke channel handlers and begins dispatching responses.
-// Responses can be polled using the pollResponses handler.
+// Responses can be polled using the pollResponses handler. If a session ID is
+// passed in the session parameter, responses are also sent to that session on
+// whichever node it's connected to, provided the request is authenticated as
+// the session's Principal.
 func (h *httpHandler) send(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(r.Body)
 
-	msg, err := h.marshaler.unmarshal(buf.Bytes())
+	msg, err := h.marshaler.Unmarshal(buf.Bytes())
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	results, done, err := h.Recv(msg)
+	if isPattern(msg.Channel) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Cannot send to pattern %s", msg.Channel)))
+		return
+	}
+
+	sessionID := r.URL.Query().Get(sessionParam)
+	if sessionID != "" && principal == nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Only authenticated requests may send responses to a session"))
+		return
+	}
+
+	metadata := map[string]string{
+		"remoteAddr": r.RemoteAddr,
+		"userAgent":  r.UserAgent(),
+	}
+	req := newRequest(msg, sessionID, principal, metadata)
+	results, done, err := h.RecvRequest(context.Background(), req)
+	if err == ErrNotAuthorized {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	result := &result{
+	result := &Result{
+		Channel:   msg.Channel,
 		Done:      false,
-		Responses: []*message{},
+		Responses: []*Message{},
+	}
+	if err := h.Persister().SaveResult(msg.ID, result); err != nil {
+		h.log.errorf("Failed to save result %s: %s", msg.ID, err)
+		go discard(results, done)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
 	}
-	h.Persister().SaveResult(msg.ID, result)
 
-	go h.dispatch(msg.ID, msg.Channel, results, done)
+	if !h.track(func() { h.dispatch(req, results, done) }) {
+		go discard(results, done)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Vessel is shutting down"))
+		return
+	}
 
 	var scheme string
 	scheme = r.URL.Scheme
 	if scheme == "" {
-		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		} else {
+			scheme = "http"
+		}
 	}
 
 	urlStr := fmt.Sprintf("%s://%s%s/message/%s", scheme, r.Host, h.URI(), msg.ID)
@@ -100,8 +180,30 @@ func (h *httpHandler) send(w http.ResponseWriter, r *http.Request) {
 }
 
 // pollResponses will return any responses messages for the message with the
-// given id.
+// given id. If a wait is given, it waits up to that long for a response
+// beyond the number the client has already seen or for the handler to
+// complete.
 func (h *httpHandler) pollResponses(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	wait, err := pollWait(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var seen int
+	if seenStr := r.URL.Query().Get("seen"); seenStr != "" {
+		if seen, err = strconv.Atoi(seenStr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
 	vars := mux.Vars(r)
 	id := vars["id"]
 	result, err := h.Persister().GetResult(id)
@@ -110,6 +212,17 @@ func (h *httpHandler) pollResponses(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.authorize(w, principal, ActionPoll, result.Channel) {
+		return
+	}
+
+	h.longPoll(r, wait, resultKey(id), func() bool {
+		if latest, err := h.Persister().GetResult(id); err == nil {
+			result = latest
+		}
+		return result.Done || len(result.Responses) > seen
+	})
+
 	resp, err := json.Marshal(result)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -122,25 +235,60 @@ func (h *httpHandler) pollResponses(w http.ResponseWriter, r *http.Request) {
 }
 
 // pollSubscriptions will return all messages on a channel since the provided
-// timestamp.
+// timestamp. Clients which pass the IDs of the messages they have with that
+// timestamp in seenId parameters also receive the others with the same
+// timestamp. If a wait is given and there are none, it waits up to that long
+// for a message to be broadcast on the channel.
 func (h *httpHandler) pollSubscription(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	channel := vars["channel"]
+	if !h.authorize(w, principal, ActionPoll, channel) {
+		return
+	}
 	var since int64
 	if sinceStr, ok := r.URL.Query()["since"]; ok {
 		var err error
 		since, err = strconv.ParseInt(sinceStr[0], 0, 64)
 		if err != nil {
-			fmt.Println(err)
+			h.log.infof("Bad since for channel %s: %s", channel, err)
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 	}
 
-	messages, err := h.Persister().GetMessages(channel, since)
+	wait, err := pollWait(r)
 	if err != nil {
-		fmt.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Timestamps have a resolution of a second, so clients resolve ties by
+	// naming the messages they already have from the since second.
+	seen := map[string]bool{}
+	for _, id := range r.URL.Query()[seenIDParam] {
+		seen[id] = true
+	}
+	from := since
+	if len(seen) > 0 {
+		from = since - 1
+	}
+
+	var messages []*Message
+	h.longPoll(r, wait, channelKey(channel), func() bool {
+		messages, err = h.Persister().GetMessages(channel, from)
+		messages = unseenMessages(messages, since, seen)
+		messages = authorizedMessages(h.authorizer, principal, ActionPoll, messages)
+		return err != nil || len(messages) > 0
+	})
+	if err != nil {
+		h.log.errorf("Failed to get messages for channel %s: %s", channel, err)
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -156,24 +304,216 @@ func (h *httpHandler) pollSubscription(w http.ResponseWriter, r *http.Request) {
 	w.Write(resp)
 }
 
+// presence lists the sessions subscribed to the channel on every node as
+// JSON. The list is empty unless presence is enabled.
+func (h *httpHandler) presence(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	channel := mux.Vars(r)["channel"]
+	if !h.authorize(w, principal, ActionPoll, channel) {
+		return
+	}
+
+	members, err := h.Members(channel)
+	if err != nil {
+		h.log.errorf("Failed to get members of channel %s: %s", channel, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if members == nil {
+		members = []*Member{}
+	}
+
+	resp, err := json.Marshal(members)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// unseenMessages returns the messages without those with the timestamp whose
+// IDs have been seen.
+func unseenMessages(messages []*Message, timestamp int64, seen map[string]bool) []*Message {
+	if len(seen) == 0 {
+		return messages
+	}
+	unseen := make([]*Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Timestamp != timestamp || !seen[msg.ID] {
+			unseen = append(unseen, msg)
+		}
+	}
+	return unseen
+}
+
+// authenticate verifies the token presented by the request if there is an
+// Authenticator, writing an error response if it's not valid. It returns the
+// authenticated Principal, which is nil without an Authenticator, and whether
+// the request may proceed.
+func (h *httpHandler) authenticate(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	if h.authenticator == nil {
+		return nil, true
+	}
+
+	principal, err := h.authenticator.Authenticate(requestToken(r))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(err.Error()))
+		return nil, false
+	}
+	return principal, true
+}
+
+// authorize checks that the Principal may perform the Action on the channel if
+// there is an Authorizer, writing an error response if it may not. It returns
+// whether the request may proceed.
+func (h *httpHandler) authorize(w http.ResponseWriter, principal *Principal, action Action,
+	channel string) bool {
+
+	if h.authorizer == nil || h.authorizer.Authorize(principal, action, channel) {
+		return true
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(ErrNotAuthorized.Error()))
+	return false
+}
+
+// longPoll calls poll until it returns true or the wait elapses, checking
+// again each time the key is notified. poll is always called at least once
+// and is called one final time if the wait elapses.
+func (h *httpHandler) longPoll(r *http.Request, wait time.Duration, key string, poll func() bool) {
+	if wait <= 0 {
+		poll()
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		if !h.waitForPoll(r, timer, key, poll) {
+			return
+		}
+	}
+}
+
+// waitForPoll calls poll and waits for the key to be notified if it returns
+// false. It returns whether to poll again.
+func (h *httpHandler) waitForPoll(r *http.Request, timer *time.Timer, key string,
+	poll func() bool) bool {
+
+	notified, done := h.notifier.wait(key)
+	defer done()
+	if poll() {
+		return false
+	}
+
+	select {
+	case <-notified:
+		return true
+	case <-timer.C:
+	case <-h.stop:
+	case <-r.Context().Done():
+		return false
+	}
+	poll()
+	return false
+}
+
+// track runs f in a goroutine which close waits for. It returns false without
+// running f once the handler is closing.
+func (h *httpHandler) track(f func()) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing {
+		return false
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		f()
+	}()
+	return true
+}
+
+// close stops the handler from dispatching responses to new messages and
+// waits for the dispatchers already running to finish.
+func (h *httpHandler) close() {
+	h.mu.Lock()
+	h.closing = true
+	h.mu.Unlock()
+	h.wg.Wait()
+}
+
+// pollWait returns how long a poll may wait for new data, given in the wait
+// query string parameter as a duration such as "30s" or a number of seconds.
+// It's capped at maxPollWait.
+func pollWait(r *http.Request) (time.Duration, error) {
+	waitStr := r.URL.Query().Get("wait")
+	if waitStr == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(waitStr)
+	if err != nil {
+		seconds, err := strconv.Atoi(waitStr)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid wait %s", waitStr)
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+	return wait, nil
+}
+
 // dispatch will listen for responses to a message and add them to the message
-// result struct for polling.
-func (h *httpHandler) dispatch(id, channel string, results <-chan string, done <-chan bool) {
+// result struct for polling, routing them to the request's session if it has
+// one. It returns once the handler has completed. If the result can't be
+// read, the handler's results are discarded.
+func (h *httpHandler) dispatch(req *Request, results <-chan string, done <-chan bool) {
+	id, channel := req.ID, req.Channel
 	persister := h.Persister()
 	r, err := persister.GetResult(id)
 	if err != nil {
-		log.Println(err)
+		h.log.errorf("Failed to get result %s: %s", id, err)
+		discard(results, done)
 		return
 	}
 
-	for {
-		select {
-		case <-done:
-			r.Done = true
-			persister.SaveResult(id, r)
-		case result := <-results:
-			r.Responses = append(r.Responses, newMessage(id, channel, result))
-			persister.SaveResult(id, r)
+	forward(results, done, nil, func(result string) {
+		msg := newMessage(id, channel, result)
+		r.Responses = append(r.Responses, msg)
+		if err := persister.SaveResult(id, r); err != nil {
+			h.log.errorf("Failed to save result %s: %s", id, err)
 		}
+		h.resultSaved(id)
+		if req.Session != "" && req.Principal != nil && h.router != nil {
+			h.router.route(req.Session, req.Principal.ID, msg)
+		}
+	})
+
+	r.Done = true
+	if err := persister.SaveResult(id, r); err != nil {
+		h.log.errorf("Failed to save result %s: %s", id, err)
+	}
+	h.resultSaved(id)
+}
+
+// resultSaved wakes polls waiting on the result, including those on other
+// nodes if there's a router.
+func (h *httpHandler) resultSaved(id string) {
+	if h.router != nil {
+		h.router.resultSaved(id)
+		return
 	}
+	h.notifier.notify(resultKey(id))
 }
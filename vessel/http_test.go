@@ -2,11 +2,15 @@ package vessel
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -22,34 +26,91 @@ func (m *mockPersister) Prepare() error {
 	return args.Error(0)
 }
 
-func (m *mockPersister) SaveResult(id string, result *result) error {
+func (m *mockPersister) SaveResult(id string, result *Result) error {
 	args := m.Mock.Called(id, result)
 	return args.Error(0)
 }
 
-func (m *mockPersister) SaveMessage(id string, message *message) error {
+func (m *mockPersister) SaveMessage(id string, message *Message) error {
 	args := m.Mock.Called(id, message)
 	return args.Error(0)
 }
 
-func (m *mockPersister) GetResult(id string) (*result, error) {
+func (m *mockPersister) GetResult(id string) (*Result, error) {
 	args := m.Mock.Called(id)
 	r := args.Get(0)
 	if r != nil {
-		return r.(*result), args.Error(1)
+		return r.(*Result), args.Error(1)
 	}
 	return nil, args.Error(1)
 }
 
-func (m *mockPersister) GetMessages(channel string, since int64) ([]*message, error) {
+func (m *mockPersister) GetMessages(channel string, since int64) ([]*Message, error) {
 	args := m.Mock.Called(channel, since)
 	messages := args.Get(0)
 	if messages != nil {
-		return messages.([]*message), args.Error(1)
+		return messages.([]*Message), args.Error(1)
 	}
 	return nil, args.Error(1)
 }
 
+func (m *mockPersister) QueueMessage(user string, message *Message) error {
+	args := m.Mock.Called(user, message)
+	return args.Error(0)
+}
+
+func (m *mockPersister) DeleteQueued(user, id string) error {
+	args := m.Mock.Called(user, id)
+	return args.Error(0)
+}
+
+func (m *mockPersister) TakeQueued(user string) ([]*Message, error) {
+	args := m.Mock.Called(user)
+	messages := args.Get(0)
+	if messages != nil {
+		return messages.([]*Message), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockPersister) AddMember(channel string, member *Member) error {
+	args := m.Mock.Called(channel, member)
+	return args.Error(0)
+}
+
+func (m *mockPersister) RemoveMember(channel, session string) error {
+	args := m.Mock.Called(channel, session)
+	return args.Error(0)
+}
+
+func (m *mockPersister) GetMembers(channel string) ([]*Member, error) {
+	args := m.Mock.Called(channel)
+	members := args.Get(0)
+	if members != nil {
+		return members.([]*Member), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *mockPersister) Heartbeat(node string, ttl time.Duration) error {
+	args := m.Mock.Called(node, ttl)
+	return args.Error(0)
+}
+
+func (m *mockPersister) SetRetention(retention RetentionPolicy) {
+	m.Mock.Called(retention)
+}
+
+func (m *mockPersister) Trim() error {
+	args := m.Mock.Called()
+	return args.Error(0)
+}
+
+func (m *mockPersister) Close() error {
+	args := m.Mock.Called()
+	return args.Error(0)
+}
+
 // Ensures that send writes an error message when the payload is bad.
 func TestSendBadRequest(t *testing.T) {
 	assert := assert.New(t)
@@ -70,6 +131,57 @@ func TestSendBadRequest(t *testing.T) {
 	assert.Equal("Message missing id", w.Body.String())
 }
 
+// textMarshaler reads messages written as "id channel body".
+type textMarshaler struct{}
+
+func (textMarshaler) Unmarshal(data []byte) (*Message, error) {
+	fields := strings.SplitN(string(data), " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("Invalid message %s", data)
+	}
+	return &Message{ID: fields[0], Channel: fields[1], Body: fields[2]}, nil
+}
+
+func (textMarshaler) Marshal(message *Message) ([]byte, error) {
+	return []byte(message.ID + " " + message.Channel + " " + message.Body), nil
+}
+
+// Ensures that send reads messages with the Vessel's Marshaler.
+func TestSendMarshaler(t *testing.T) {
+	assert := assert.New(t)
+	v := NewSockJSVesselWithOptions("/vessel", &Options{
+		Persister: NewMemoryPersister(0),
+		Marshaler: textMarshaler{},
+	})
+	received := make(chan string, 1)
+	v.AddHandler("foo", func(ctx context.Context, req *Request, results chan<- string) {
+		received <- req.Body
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/vessel", strings.NewReader("abc foo hello world"))
+
+	v.Handler().ServeHTTP(w, req)
+
+	assert.Equal(http.StatusAccepted, w.Code)
+	assert.Equal("hello world", <-received)
+}
+
+// Ensures that send rejects messages sent to a pattern as bad requests.
+func TestSendPattern(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	handler := newHTTPHandler(mockVessel)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/vessel",
+		strings.NewReader(`{"id":"abc","channel":"orders.*","body":"bar","timestamp":1412003438}`))
+
+	handler.send(w, req)
+
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal("Cannot send to pattern orders.*", w.Body.String())
+	mockVessel.Mock.AssertNotCalled(t, "RecvRequest", mock.Anything, mock.Anything)
+}
+
 // Ensures that send writes an error message when Recv fails.
 func TestSendRecvFail(t *testing.T) {
 	assert := assert.New(t)
@@ -85,7 +197,13 @@ func TestSendRecvFail(t *testing.T) {
 	jsonPayload, _ := json.Marshal(payload)
 	reader := bytes.NewReader(jsonPayload)
 	req, _ := http.NewRequest("POST", "http://example.com/vessel", reader)
-	mockVessel.On("Recv", &message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412003438}).
+	mockVessel.On("RecvRequest", mock.Anything, &Request{
+		ID:        "abc",
+		Channel:   "foo",
+		Body:      "bar",
+		Timestamp: 1412003438,
+		Metadata:  map[string]string{"remoteAddr": "", "userAgent": ""},
+	}).
 		Return(make(<-chan string), make(<-chan bool), fmt.Errorf("error"))
 
 	handler.send(w, req)
@@ -95,6 +213,31 @@ func TestSendRecvFail(t *testing.T) {
 	assert.Equal("error", w.Body.String())
 }
 
+// Ensures that send writes an error and drains the handler's results when the
+// result can't be saved.
+func TestSendSaveResultFail(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	mockPersister := new(mockPersister)
+	handler := newHTTPHandler(mockVessel)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/vessel",
+		strings.NewReader(`{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`))
+	results := make(chan string)
+	done := make(chan bool)
+	mockVessel.On("RecvRequest", mock.Anything, mock.Anything).Return((<-chan string)(results), (<-chan bool)(done), nil)
+	mockVessel.On("Persister").Return(mockPersister)
+	mockPersister.On("SaveResult", "abc", mock.Anything).Return(fmt.Errorf("error"))
+
+	handler.send(w, req)
+	results <- "foo"
+	done <- true
+
+	mockVessel.Mock.AssertExpectations(t)
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("error", w.Body.String())
+}
+
 // Ensures that send dispatches the message and writes the resource URL.
 func TestSend(t *testing.T) {
 	assert := assert.New(t)
@@ -111,10 +254,16 @@ func TestSend(t *testing.T) {
 	jsonPayload, _ := json.Marshal(payload)
 	reader := bytes.NewReader(jsonPayload)
 	req, _ := http.NewRequest("POST", "http://example.com/vessel", reader)
-	mockVessel.On("Recv", &message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412003438}).
+	mockVessel.On("RecvRequest", mock.Anything, &Request{
+		ID:        "abc",
+		Channel:   "foo",
+		Body:      "bar",
+		Timestamp: 1412003438,
+		Metadata:  map[string]string{"remoteAddr": "", "userAgent": ""},
+	}).
 		Return(make(<-chan string), make(<-chan bool), nil)
 	mockVessel.On("Persister").Return(mockPersister)
-	result := &result{Done: false, Responses: []*message{}}
+	result := &Result{Channel: "foo", Done: false, Responses: []*Message{}}
 	mockPersister.On("SaveResult", "abc", result).Return(nil)
 	mockPersister.On("GetResult", "abc").Return(nil, fmt.Errorf("error"))
 	mockVessel.On("URI").Return("/vessel")
@@ -128,6 +277,69 @@ func TestSend(t *testing.T) {
 		w.Body.String())
 }
 
+// Ensures that send writes an https resource URL for requests received over
+// TLS.
+func TestSendTLS(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	mockPersister := new(mockPersister)
+	handler := newHTTPHandler(mockVessel)
+	w := httptest.NewRecorder()
+	payload := `{"id": "abc", "channel": "foo", "body": "bar", "timestamp": 1412003438}`
+	req := httptest.NewRequest("POST", "/vessel", strings.NewReader(payload))
+	req.TLS = &tls.ConnectionState{}
+	mockVessel.On("RecvRequest", mock.Anything, mock.Anything).
+		Return(make(<-chan string), make(<-chan bool), nil)
+	mockVessel.On("Persister").Return(mockPersister)
+	mockPersister.On("SaveResult", "abc", mock.Anything).Return(nil)
+	mockPersister.On("GetResult", "abc").Return(nil, fmt.Errorf("error"))
+	mockVessel.On("URI").Return("/vessel")
+
+	handler.send(w, req)
+
+	assert.Equal(http.StatusAccepted, w.Code)
+	assert.Equal(
+		`{"channel":"foo","id":"abc","responses":"https://example.com/vessel/message/abc"}`,
+		w.Body.String())
+}
+
+// Ensures that send rejects requests which fail authentication.
+func TestSendUnauthorized(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	handler := newHTTPHandler(mockVessel)
+	handler.authenticator = AuthenticatorFunc(mockAuthenticator)
+	w := httptest.NewRecorder()
+	payload := `{"id": "abc", "channel": "foo", "body": "bar", "timestamp": 1412003438}`
+	req, _ := http.NewRequest("POST", "http://example.com/vessel", strings.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer bad")
+
+	handler.send(w, req)
+
+	mockVessel.Mock.AssertNotCalled(t, "RecvRequest", mock.Anything, mock.Anything)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+}
+
+// Ensures that send passes the authenticated Principal to Recv.
+func TestSendAuthenticated(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	handler := newHTTPHandler(mockVessel)
+	handler.authenticator = AuthenticatorFunc(mockAuthenticator)
+	w := httptest.NewRecorder()
+	payload := `{"id": "abc", "channel": "foo", "body": "bar", "timestamp": 1412003438}`
+	req, _ := http.NewRequest("POST", "http://example.com/vessel", strings.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer secret")
+	mockVessel.On("RecvRequest", mock.Anything, mock.MatchedBy(func(req *Request) bool {
+		return req.Principal != nil && req.Principal.ID == "user"
+	})).Return(make(<-chan string), make(<-chan bool), fmt.Errorf("error"))
+
+	handler.send(w, req)
+
+	mockVessel.Mock.AssertExpectations(t)
+	assert.Equal(http.StatusInternalServerError, w.Code)
+}
+
 // Ensures that pollResponses writes an error message when there is no message.
 func TestPollResponsesNoMessage(t *testing.T) {
 	assert := assert.New(t)
@@ -156,9 +368,9 @@ func TestPollHandler(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://example.com/vessel/message/abc", nil)
 	r := router(handler.pollResponses)
 	mockVessel.On("Persister").Return(mockPersister)
-	result := &result{
+	result := &Result{
 		Done:      true,
-		Responses: []*message{&message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412003438}},
+		Responses: []*Message{&Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412003438}},
 	}
 	mockPersister.On("GetResult", "abc").Return(result, nil)
 
@@ -171,8 +383,286 @@ func TestPollHandler(t *testing.T) {
 	)
 }
 
+// Ensures that send writes a forbidden status when Recv denies the message.
+func TestSendNotAuthorized(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	handler := newHTTPHandler(mockVessel)
+	w := httptest.NewRecorder()
+	payload := `{"id": "abc", "channel": "foo", "body": "bar", "timestamp": 1412003438}`
+	req, _ := http.NewRequest("POST", "http://example.com/vessel", strings.NewReader(payload))
+	mockVessel.On("RecvRequest", mock.Anything, mock.Anything).
+		Return(make(<-chan string), make(<-chan bool), ErrNotAuthorized)
+
+	handler.send(w, req)
+
+	assert.Equal(http.StatusForbidden, w.Code)
+}
+
+// Ensures that pollResponses checks that the client may poll the result's
+// channel.
+func TestPollResponsesNotAuthorized(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	mockPersister := new(mockPersister)
+	handler := newHTTPHandler(mockVessel)
+	handler.authorizer = Rules{{Pattern: "public"}}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/message/abc", nil)
+	r := router(handler.pollResponses)
+	mockVessel.On("Persister").Return(mockPersister)
+	mockPersister.On("GetResult", "abc").Return(&Result{Channel: "private", Done: true}, nil)
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusForbidden, w.Code)
+}
+
+// Ensures that pollSubscription checks that the client may poll the channel's
+// history.
+func TestPollSubscriptionNotAuthorized(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	handler := newHTTPHandler(mockVessel)
+	handler.authorizer = Rules{{Pattern: "public"}}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/channel/private", nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/vessel/channel/{channel}", handler.pollSubscription)
+
+	r.ServeHTTP(w, req)
+
+	mockVessel.Mock.AssertNotCalled(t, "Persister")
+	assert.Equal(http.StatusForbidden, w.Code)
+}
+
+// Ensures that presence lists the members of the channel as JSON.
+func TestPresence(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	handler := newHTTPHandler(mockVessel)
+	mockVessel.On("Members", "foo").Return([]*Member{{ID: "m1", Principal: "user", Joined: 1412003438}}, nil)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/presence/foo", nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/vessel/presence/{channel}", handler.presence)
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`[{"id":"m1","principal":"user","joined":1412003438}]`, w.Body.String())
+}
+
+// Ensures that presence returns an empty list for channels without members.
+func TestPresenceEmpty(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/presence/foo", nil)
+
+	vessel.Handler().ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("[]", w.Body.String())
+}
+
+// Ensures that presence rejects clients which aren't authorized to poll the
+// channel.
+func TestPresenceNotAuthorized(t *testing.T) {
+	mockVessel := new(mockVessel)
+	handler := newHTTPHandler(mockVessel)
+	handler.authorizer = Rules{{Pattern: "public"}}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/presence/private", nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/vessel/presence/{channel}", handler.presence)
+
+	r.ServeHTTP(w, req)
+
+	mockVessel.Mock.AssertNotCalled(t, "Members", mock.Anything)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
 func router(handler http.HandlerFunc) *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/vessel/message/{id}", handler)
 	return r
 }
+
+// Ensures that dispatch saves all responses, marks the result done and
+// returns once the handler has completed.
+func TestDispatch(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	mockPersister := new(mockPersister)
+	handler := newHTTPHandler(mockVessel)
+	result := &Result{Done: false, Responses: []*Message{}}
+	mockVessel.On("Persister").Return(mockPersister)
+	mockPersister.On("GetResult", "abc").Return(result, nil)
+	mockPersister.On("SaveResult", "abc", result).Return(nil)
+	results := make(chan string, 2)
+	done := make(chan bool, 1)
+	results <- "foo"
+	results <- "bar"
+	done <- true
+
+	handler.dispatch(&Request{ID: "abc", Channel: "baz"}, results, done)
+
+	assert.True(result.Done)
+	if assert.Equal(2, len(result.Responses)) {
+		assert.Equal("foo", result.Responses[0].Body)
+		assert.Equal("bar", result.Responses[1].Body)
+	}
+}
+
+// Ensures that dispatch drains the handler's results when the result can't be
+// read, so the handler doesn't block and dispatch still returns.
+func TestDispatchGetResultError(t *testing.T) {
+	mockVessel := new(mockVessel)
+	mockPersister := new(mockPersister)
+	handler := newHTTPHandler(mockVessel)
+	mockVessel.On("Persister").Return(mockPersister)
+	mockPersister.On("GetResult", "abc").Return(nil, fmt.Errorf("error"))
+	results := make(chan string)
+	done := make(chan bool)
+	go func() {
+		results <- "foo"
+		done <- true
+	}()
+
+	handler.dispatch(&Request{ID: "abc", Channel: "baz"}, results, done)
+
+	mockPersister.AssertNotCalled(t, "SaveResult", mock.Anything, mock.Anything)
+}
+
+// Ensures that pollResponses waits for a response beyond those the client has
+// seen.
+func TestPollResponsesWait(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	persister := NewMemoryPersister(0)
+	handler := newHTTPHandler(mockVessel)
+	mockVessel.On("Persister").Return(persister)
+	persister.SaveResult("abc", &Result{Channel: "foo", Responses: []*Message{}})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/message/abc?wait=5s&seen=0", nil)
+	results := make(chan string, 1)
+	done := make(chan bool, 1)
+	results <- "bar"
+	done <- true
+
+	go handler.dispatch(&Request{ID: "abc", Channel: "foo"}, results, done)
+	router(handler.pollResponses).ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	var result Result
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &result))
+	if assert.Len(result.Responses, 1) {
+		assert.Equal("bar", result.Responses[0].Body)
+	}
+}
+
+// Ensures that pollResponses returns the current result once the wait
+// elapses.
+func TestPollResponsesWaitTimeout(t *testing.T) {
+	assert := assert.New(t)
+	mockVessel := new(mockVessel)
+	persister := NewMemoryPersister(0)
+	handler := newHTTPHandler(mockVessel)
+	mockVessel.On("Persister").Return(persister)
+	persister.SaveResult("abc", &Result{Channel: "foo", Responses: []*Message{}})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/message/abc?wait=10ms", nil)
+
+	router(handler.pollResponses).ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`{"channel":"foo","done":false,"responses":[]}`, w.Body.String())
+}
+
+// Ensures that pollSubscription waits for a message to be broadcast when
+// there are none since the timestamp.
+func TestPollSubscriptionWait(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/channel/foo?wait=5", nil)
+
+	go vessel.Broadcast("foo", "bar")
+	vessel.Handler().ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	var messages []*Message
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &messages))
+	if assert.Len(messages, 1) {
+		assert.Equal("bar", messages[0].Body)
+	}
+}
+
+// Ensures that channel polls leave out the messages named by seenId at the
+// since timestamp and return the others.
+func TestPollSubscriptionSeen(t *testing.T) {
+	assert := assert.New(t)
+	persister := NewMemoryPersister(0)
+	persister.SaveMessage("foo", &Message{ID: "a", Channel: "foo", Body: "a", Timestamp: 99})
+	persister.SaveMessage("foo", &Message{ID: "b", Channel: "foo", Body: "b", Timestamp: 100})
+	persister.SaveMessage("foo", &Message{ID: "c", Channel: "foo", Body: "c", Timestamp: 100})
+	persister.SaveMessage("foo", &Message{ID: "d", Channel: "foo", Body: "d", Timestamp: 101})
+	vessel := NewSockJSVesselWithOptions("/vessel", &Options{Persister: persister})
+	poll := func(query string) []string {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://example.com/vessel/channel/foo?"+query, nil)
+		vessel.Handler().ServeHTTP(w, req)
+		var messages []*Message
+		json.Unmarshal(w.Body.Bytes(), &messages)
+		ids := []string{}
+		for _, msg := range messages {
+			ids = append(ids, msg.ID)
+		}
+		return ids
+	}
+
+	assert.Equal([]string{"d"}, poll("since=100"))
+	assert.Equal([]string{"c", "d"}, poll("since=100&seenId=b"))
+	assert.Equal([]string{}, poll("since=101&seenId=d"))
+}
+
+// Ensures that polling a pattern waits for a message on any channel it
+// matches.
+func TestPollSubscriptionPattern(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/vessel/channel/orders.*?wait=5", nil)
+
+	go vessel.Broadcast("orders.eu", "bar")
+	vessel.Handler().ServeHTTP(w, req)
+
+	assert.Equal(http.StatusOK, w.Code)
+	var messages []*Message
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &messages))
+	if assert.Len(messages, 1) {
+		assert.Equal("orders.eu", messages[0].Channel)
+	}
+}
+
+// Ensures that pollWait parses durations and seconds and caps the wait.
+func TestPollWait(t *testing.T) {
+	assert := assert.New(t)
+	wait := func(query string) (time.Duration, error) {
+		req, _ := http.NewRequest("GET", "http://example.com/vessel/channel/foo"+query, nil)
+		return pollWait(req)
+	}
+
+	d, err := wait("")
+	assert.Equal(time.Duration(0), d)
+	assert.Nil(err)
+	d, _ = wait("?wait=1500ms")
+	assert.Equal(1500*time.Millisecond, d)
+	d, _ = wait("?wait=30")
+	assert.Equal(30*time.Second, d)
+	d, _ = wait("?wait=1h")
+	assert.Equal(maxPollWait, d)
+	_, err = wait("?wait=soon")
+	assert.NotNil(err)
+}
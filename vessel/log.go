@@ -0,0 +1,73 @@
+package vessel
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// LogLevel controls which of the Vessel's log messages are written.
+type LogLevel int
+
+const (
+	// LogDebug logs every message received and sent as well as everything
+	// logged at LogInfo.
+	LogDebug LogLevel = iota
+
+	// LogInfo logs rejected clients and messages and disconnections as well
+	// as errors.
+	LogInfo
+
+	// LogError logs only errors.
+	LogError
+
+	// LogOff disables logging.
+	LogOff
+)
+
+// ParseLogLevel returns the LogLevel named "debug", "info", "error" or "off".
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return LogDebug, nil
+	case "info":
+		return LogInfo, nil
+	case "error":
+		return LogError, nil
+	case "off":
+		return LogOff, nil
+	}
+	return LogOff, fmt.Errorf("Unknown log level %s", level)
+}
+
+// logger writes log messages at or above its level.
+type logger struct {
+	level LogLevel
+	out   *log.Logger
+}
+
+func newLogger(level LogLevel, out *log.Logger) *logger {
+	if out == nil {
+		out = log.Default()
+	}
+	return &logger{level: level, out: out}
+}
+
+func (l *logger) debugf(format string, args ...interface{}) {
+	l.logf(LogDebug, format, args...)
+}
+
+func (l *logger) infof(format string, args ...interface{}) {
+	l.logf(LogInfo, format, args...)
+}
+
+func (l *logger) errorf(format string, args ...interface{}) {
+	l.logf(LogError, format, args...)
+}
+
+func (l *logger) logf(level LogLevel, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.out.Output(3, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,34 @@
+package vessel
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that ParseLogLevel parses level names case-insensitively.
+func TestParseLogLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	level, err := ParseLogLevel("INFO")
+	assert.Nil(err)
+	assert.Equal(LogInfo, level)
+	level, _ = ParseLogLevel("off")
+	assert.Equal(LogOff, level)
+	_, err = ParseLogLevel("verbose")
+	assert.NotNil(err)
+}
+
+// Ensures that logger only writes messages at or above its level.
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := newLogger(LogInfo, log.New(&buf, "", 0))
+
+	l.debugf("debug %d", 1)
+	l.infof("info %d", 2)
+	l.errorf("error %d", 3)
+
+	assert.Equal(t, "info 2\nerror 3\n", buf.String())
+}
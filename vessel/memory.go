@@ -0,0 +1,324 @@
+package vessel
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultMaxMessages = 1000
+
+type memoryPersister struct {
+	results     map[string]*memoryResult
+	messages    map[string][]*Message
+	queues      map[string][]*Message
+	members     map[string]map[string]*Member
+	maxMessages int
+	retention   RetentionPolicy
+	now         func() time.Time
+	mu          sync.RWMutex
+}
+
+// memoryResult is a stored Result along with when it was saved.
+type memoryResult struct {
+	result *Result
+	saved  time.Time
+}
+
+// NewMemoryPersister returns a new Persister which keeps results and messages
+// in memory. At most maxMessages are retained per channel and queued per
+// user, discarding the oldest first. If maxMessages is not positive, a default
+// of 1000 is used.
+func NewMemoryPersister(maxMessages int) Persister {
+	if maxMessages <= 0 {
+		maxMessages = defaultMaxMessages
+	}
+	return &memoryPersister{
+		results:     map[string]*memoryResult{},
+		messages:    map[string][]*Message{},
+		queues:      map[string][]*Message{},
+		members:     map[string]map[string]*Member{},
+		maxMessages: maxMessages,
+		now:         time.Now,
+		mu:          sync.RWMutex{},
+	}
+}
+
+func (m *memoryPersister) Prepare() error {
+	return nil
+}
+
+func (m *memoryPersister) SaveResult(id string, result *Result) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.results[id] = &memoryResult{result: copyResult(result), saved: m.now()}
+	return nil
+}
+
+func (m *memoryPersister) SaveMessage(channel string, message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Keep messages ordered by timestamp, preserving insertion order for
+	// messages with the same timestamp.
+	messages := m.messages[channel]
+	i := sort.Search(len(messages), func(i int) bool {
+		return messages[i].Timestamp > message.Timestamp
+	})
+	messages = append(messages, nil)
+	copy(messages[i+1:], messages[i:])
+	messages[i] = message
+
+	m.messages[channel] = messages
+	m.trimChannel(channel)
+	return nil
+}
+
+func (m *memoryPersister) GetResult(id string) (*Result, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result, ok := m.results[id]
+	if !ok || m.expired(result) {
+		return nil, fmt.Errorf("No result for %s", id)
+	}
+
+	return copyResult(result.result), nil
+}
+
+// GetMessages returns the messages on the channel since the timestamp. If
+// the channel is a pattern, the messages on every channel it matches are
+// returned in timestamp order.
+func (m *memoryPersister) GetMessages(channel string, since int64) ([]*Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Skip messages which have outlived the retention policy but haven't
+	// been trimmed yet.
+	if cutoff, ok := m.messageCutoff(); ok && cutoff > since {
+		since = cutoff
+	}
+
+	if !isPattern(channel) {
+		return messagesSince(m.messages[channel], since), nil
+	}
+	result := []*Message{}
+	for name, messages := range m.messages {
+		if MatchChannel(channel, name) {
+			result = append(result, messagesSince(messages, since)...)
+		}
+	}
+	sortMessages(result)
+	return result, nil
+}
+
+// messagesSince returns a copy of the messages, which are ordered by
+// timestamp, that are newer than the timestamp.
+func messagesSince(messages []*Message, since int64) []*Message {
+	i := sort.Search(len(messages), func(i int) bool {
+		return messages[i].Timestamp > since
+	})
+
+	result := make([]*Message, len(messages)-i)
+	copy(result, messages[i:])
+	return result
+}
+
+// QueueMessage appends the message to the user's queue.
+func (m *memoryPersister) QueueMessage(user string, message *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue := append(m.queues[user], message)
+	if len(queue) > m.maxMessages {
+		queue = queue[len(queue)-m.maxMessages:]
+	}
+	m.queues[user] = queue
+	return nil
+}
+
+// DeleteQueued removes the message with the ID from the user's queue.
+func (m *memoryPersister) DeleteQueued(user, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue := m.queues[user]
+	for i, message := range queue {
+		if message.ID == id {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(m.queues, user)
+		return nil
+	}
+	m.queues[user] = queue
+	return nil
+}
+
+// TakeQueued removes and returns the user's queue, skipping messages which
+// have outlived the retention policy.
+func (m *memoryPersister) TakeQueued(user string) ([]*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue := m.queues[user]
+	delete(m.queues, user)
+	cutoff, ok := m.messageCutoff()
+	messages := make([]*Message, 0, len(queue))
+	for _, message := range queue {
+		if !ok || message.Timestamp > cutoff {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// AddMember adds the member to the channel, replacing any with the same ID.
+func (m *memoryPersister) AddMember(channel string, member *Member) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members, ok := m.members[channel]
+	if !ok {
+		members = map[string]*Member{}
+		m.members[channel] = members
+	}
+	c := *member
+	members[member.ID] = &c
+	return nil
+}
+
+func (m *memoryPersister) RemoveMember(channel, session string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members := m.members[channel]
+	delete(members, session)
+	if len(members) == 0 {
+		delete(m.members, channel)
+	}
+	return nil
+}
+
+// Heartbeat does nothing since members are kept in the node's own memory.
+func (m *memoryPersister) Heartbeat(node string, ttl time.Duration) error {
+	return nil
+}
+
+// GetMembers returns copies of the channel's members.
+func (m *memoryPersister) GetMembers(channel string) ([]*Member, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	members := make([]*Member, 0, len(m.members[channel]))
+	for _, member := range m.members[channel] {
+		c := *member
+		members = append(members, &c)
+	}
+	return members, nil
+}
+
+// SetRetention sets the RetentionPolicy. A MaxMessages larger than the limit
+// the Persister was created with has no effect.
+func (m *memoryPersister) SetRetention(retention RetentionPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retention = retention
+}
+
+// Trim removes expired results and messages outside the retention policy.
+func (m *memoryPersister) Trim() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, result := range m.results {
+		if m.expired(result) {
+			delete(m.results, id)
+		}
+	}
+
+	for channel := range m.messages {
+		m.trimChannel(channel)
+	}
+
+	if cutoff, ok := m.messageCutoff(); ok {
+		for user, queue := range m.queues {
+			kept := queue[:0]
+			for _, message := range queue {
+				if message.Timestamp > cutoff {
+					kept = append(kept, message)
+				}
+			}
+			if len(kept) == 0 {
+				delete(m.queues, user)
+			} else {
+				m.queues[user] = kept
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close does nothing since there are no resources to release.
+func (m *memoryPersister) Close() error {
+	return nil
+}
+
+// trimChannel removes messages outside the retention policy from the channel.
+// The caller must hold the write lock.
+func (m *memoryPersister) trimChannel(channel string) {
+	messages := m.messages[channel]
+
+	if cutoff, ok := m.messageCutoff(); ok {
+		i := sort.Search(len(messages), func(i int) bool {
+			return messages[i].Timestamp > cutoff
+		})
+		messages = messages[i:]
+	}
+
+	max := m.maxMessages
+	if m.retention.MaxMessages > 0 && m.retention.MaxMessages < max {
+		max = m.retention.MaxMessages
+	}
+	if len(messages) > max {
+		messages = messages[len(messages)-max:]
+	}
+
+	if len(messages) == 0 {
+		delete(m.messages, channel)
+		return
+	}
+	m.messages[channel] = messages
+}
+
+// messageCutoff returns the timestamp at or before which messages have
+// outlived the retention policy and whether the policy limits message age.
+func (m *memoryPersister) messageCutoff() (int64, bool) {
+	if m.retention.MessageTTL <= 0 {
+		return 0, false
+	}
+	return m.now().Add(-m.retention.MessageTTL).Unix() - 1, true
+}
+
+// expired indicates if the result has outlived the retention policy.
+func (m *memoryPersister) expired(result *memoryResult) bool {
+	ttl := m.retention.ResultTTL
+	return ttl > 0 && m.now().Sub(result.saved) >= ttl
+}
+
+// copyResult returns a copy of the Result so that callers can't modify the
+// stored responses.
+func copyResult(result *Result) *Result {
+	responses := make([]*Message, len(result.Responses))
+	copy(responses, result.Responses)
+	return &Result{
+		Channel:   result.Channel,
+		Done:      result.Done,
+		Responses: responses,
+	}
+}
@@ -0,0 +1,231 @@
+package vessel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that GetResult returns a copy of the saved result.
+func TestMemoryGetResult(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	result := &Result{
+		Done:      false,
+		Responses: []*Message{&Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412003438}},
+	}
+
+	assert.Nil(m.SaveResult("abc", result))
+	result.Done = true
+	saved, err := m.GetResult("abc")
+
+	assert.Nil(err)
+	if assert.NotNil(saved) {
+		assert.False(saved.Done)
+		assert.Equal(1, len(saved.Responses))
+	}
+}
+
+// Ensures that GetResult returns an error when there is no result.
+func TestMemoryGetResultMissing(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+
+	result, err := m.GetResult("abc")
+
+	assert.Nil(result)
+	assert.NotNil(err)
+}
+
+// Ensures that GetMessages returns messages after the timestamp in time order.
+func TestMemoryGetMessages(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	m.SaveMessage("foo", &Message{ID: "c", Channel: "foo", Body: "c", Timestamp: 30})
+	m.SaveMessage("foo", &Message{ID: "a", Channel: "foo", Body: "a", Timestamp: 10})
+	m.SaveMessage("foo", &Message{ID: "b", Channel: "foo", Body: "b", Timestamp: 20})
+	m.SaveMessage("bar", &Message{ID: "d", Channel: "bar", Body: "d", Timestamp: 40})
+
+	messages, err := m.GetMessages("foo", 10)
+
+	assert.Nil(err)
+	if assert.Equal(2, len(messages)) {
+		assert.Equal("b", messages[0].ID)
+		assert.Equal("c", messages[1].ID)
+	}
+}
+
+// Ensures that GetMessages returns an empty slice for unknown channels.
+func TestMemoryGetMessagesEmpty(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+
+	messages, err := m.GetMessages("foo", 0)
+
+	assert.Nil(err)
+	assert.Equal([]*Message{}, messages)
+}
+
+// Ensures that SaveMessage discards the oldest messages beyond the limit.
+func TestMemorySaveMessageRetention(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(2).(*memoryPersister)
+	m.SaveMessage("foo", &Message{ID: "a", Channel: "foo", Body: "a", Timestamp: 10})
+	m.SaveMessage("foo", &Message{ID: "b", Channel: "foo", Body: "b", Timestamp: 20})
+	m.SaveMessage("foo", &Message{ID: "c", Channel: "foo", Body: "c", Timestamp: 30})
+
+	messages, err := m.GetMessages("foo", 0)
+
+	assert.Nil(err)
+	if assert.Equal(2, len(messages)) {
+		assert.Equal("b", messages[0].ID)
+		assert.Equal("c", messages[1].ID)
+	}
+}
+
+// Ensures that results expire after the retention policy's ResultTTL.
+func TestMemoryResultTTL(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	now := time.Unix(1412003438, 0)
+	m.now = func() time.Time { return now }
+	m.SetRetention(RetentionPolicy{ResultTTL: time.Minute})
+	m.SaveResult("abc", &Result{Done: true, Responses: []*Message{}})
+
+	now = now.Add(30 * time.Second)
+	_, err := m.GetResult("abc")
+	assert.Nil(err)
+
+	now = now.Add(30 * time.Second)
+	_, err = m.GetResult("abc")
+	assert.NotNil(err)
+
+	assert.Nil(m.Trim())
+	assert.Equal(0, len(m.results))
+}
+
+// Ensures that messages older than the retention policy's MessageTTL are
+// trimmed.
+func TestMemoryMessageTTL(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	m.now = func() time.Time { return time.Unix(100, 0) }
+	m.SetRetention(RetentionPolicy{MessageTTL: 30 * time.Second})
+	m.SaveMessage("foo", &Message{ID: "a", Channel: "foo", Body: "a", Timestamp: 60})
+	m.SaveMessage("foo", &Message{ID: "b", Channel: "foo", Body: "b", Timestamp: 80})
+	m.SaveMessage("bar", &Message{ID: "c", Channel: "bar", Body: "c", Timestamp: 90})
+
+	messages, _ := m.GetMessages("foo", 0)
+	if assert.Equal(1, len(messages)) {
+		assert.Equal("b", messages[0].ID)
+	}
+
+	m.now = func() time.Time { return time.Unix(115, 0) }
+	messages, _ = m.GetMessages("foo", 0)
+	assert.Equal(0, len(messages))
+
+	assert.Nil(m.Trim())
+	assert.Equal(1, len(m.messages))
+	assert.Equal(1, len(m.messages["bar"]))
+}
+
+// Ensures that the retention policy's MaxMessages limits channel history.
+func TestMemoryMaxMessages(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	m.SetRetention(RetentionPolicy{MaxMessages: 1})
+	m.SaveMessage("foo", &Message{ID: "a", Channel: "foo", Body: "a", Timestamp: 10})
+	m.SaveMessage("foo", &Message{ID: "b", Channel: "foo", Body: "b", Timestamp: 20})
+
+	messages, err := m.GetMessages("foo", 0)
+
+	assert.Nil(err)
+	if assert.Equal(1, len(messages)) {
+		assert.Equal("b", messages[0].ID)
+	}
+}
+
+// Ensures that TakeQueued returns the user's queued messages once, without the
+// ones which were deleted.
+func TestMemoryTakeQueued(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	m.QueueMessage("user", &Message{ID: "a", Channel: "foo", Body: "a", Timestamp: 10})
+	m.QueueMessage("user", &Message{ID: "b", Channel: "foo", Body: "b", Timestamp: 20})
+	m.QueueMessage("user", &Message{ID: "c", Channel: "foo", Body: "c", Timestamp: 30})
+	m.QueueMessage("other", &Message{ID: "d", Channel: "foo", Body: "d", Timestamp: 40})
+
+	assert.Nil(m.DeleteQueued("user", "b"))
+	messages, err := m.TakeQueued("user")
+
+	assert.Nil(err)
+	if assert.Equal(2, len(messages)) {
+		assert.Equal("a", messages[0].ID)
+		assert.Equal("c", messages[1].ID)
+	}
+	messages, _ = m.TakeQueued("user")
+	assert.Equal(0, len(messages))
+	messages, _ = m.TakeQueued("other")
+	assert.Equal(1, len(messages))
+}
+
+// Ensures that queued messages older than the retention policy's MessageTTL
+// are trimmed.
+func TestMemoryQueueMessageTTL(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	m.now = func() time.Time { return time.Unix(100, 0) }
+	m.SetRetention(RetentionPolicy{MessageTTL: 30 * time.Second})
+	m.QueueMessage("user", &Message{ID: "a", Channel: "foo", Body: "a", Timestamp: 60})
+	m.QueueMessage("user", &Message{ID: "b", Channel: "foo", Body: "b", Timestamp: 80})
+
+	assert.Nil(m.Trim())
+
+	if assert.Equal(1, len(m.queues["user"])) {
+		assert.Equal("b", m.queues["user"][0].ID)
+	}
+}
+
+// Ensures that GetMembers returns copies of the channel's members.
+func TestMemoryMembers(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	member := &Member{ID: "s1", Joined: 10}
+	assert.Nil(m.AddMember("foo", member))
+	assert.Nil(m.AddMember("foo", &Member{ID: "s2", Joined: 20}))
+	assert.Nil(m.AddMember("bar", &Member{ID: "s1", Joined: 30}))
+	member.Joined = 40
+
+	assert.Nil(m.RemoveMember("foo", "s2"))
+	members, err := m.GetMembers("foo")
+
+	assert.Nil(err)
+	if assert.Len(members, 1) {
+		assert.Equal("s1", members[0].ID)
+		assert.Equal(int64(10), members[0].Joined)
+	}
+	members, _ = m.GetMembers("baz")
+	assert.Len(members, 0)
+}
+
+// Ensures that GetMessages returns the messages on every channel matching a
+// pattern in timestamp order.
+func TestMemoryGetMessagesPattern(t *testing.T) {
+	assert := assert.New(t)
+	m := NewMemoryPersister(0).(*memoryPersister)
+	m.SaveMessage("orders.eu", &Message{ID: "a", Channel: "orders.eu", Body: "a", Timestamp: 20})
+	m.SaveMessage("orders.us", &Message{ID: "b", Channel: "orders.us", Body: "b", Timestamp: 10})
+	m.SaveMessage("orders.eu", &Message{ID: "c", Channel: "orders.eu", Body: "c", Timestamp: 30})
+	m.SaveMessage("orders.eu.new", &Message{ID: "d", Channel: "orders.eu.new", Body: "d", Timestamp: 40})
+
+	messages, err := m.GetMessages("orders.*", 10)
+
+	assert.Nil(err)
+	if assert.Len(messages, 2) {
+		assert.Equal("a", messages[0].ID)
+		assert.Equal("c", messages[1].ID)
+	}
+	messages, _ = m.GetMessages("orders.>", 0)
+	assert.Len(messages, 4)
+}
@@ -0,0 +1,90 @@
+package vessel
+
+import (
+	"strings"
+	"sync"
+)
+
+// channelKeyPrefix prefixes the notifier keys for messages on channels.
+const channelKeyPrefix = "channel:"
+
+// notifier wakes up goroutines waiting for something to change, such as a
+// result receiving a response or a message being broadcast on a channel.
+type notifier struct {
+	waiters map[string]*waiters
+	mu      sync.Mutex
+}
+
+// waiters is the channel closed to wake up everything waiting on a key and
+// how many are waiting.
+type waiters struct {
+	c     chan struct{}
+	count int
+}
+
+func newNotifier() *notifier {
+	return &notifier{waiters: map[string]*waiters{}}
+}
+
+// wait returns a channel which is closed the next time the key is notified
+// and a function which must be called once the caller stops waiting. To avoid
+// missing notifications, call wait before checking for changes.
+func (n *notifier) wait(key string) (<-chan struct{}, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w, ok := n.waiters[key]
+	if !ok {
+		w = &waiters{c: make(chan struct{})}
+		n.waiters[key] = w
+	}
+	w.count++
+
+	return w.c, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.waiters[key] != w {
+			// Already notified.
+			return
+		}
+		w.count--
+		if w.count == 0 {
+			delete(n.waiters, key)
+		}
+	}
+}
+
+// notify wakes up everything waiting on the key.
+func (n *notifier) notify(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if w, ok := n.waiters[key]; ok {
+		close(w.c)
+		delete(n.waiters, key)
+	}
+}
+
+// notifyChannel wakes up everything waiting on messages on the channel,
+// including polls of patterns matching it.
+func (n *notifier) notifyChannel(channel string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for key, w := range n.waiters {
+		if !strings.HasPrefix(key, channelKeyPrefix) {
+			continue
+		}
+		if pattern := key[len(channelKeyPrefix):]; MatchChannel(pattern, channel) {
+			close(w.c)
+			delete(n.waiters, key)
+		}
+	}
+}
+
+// resultKey returns the notifier key for the result of a message.
+func resultKey(id string) string {
+	return "result:" + id
+}
+
+// channelKey returns the notifier key for messages on a channel.
+func channelKey(channel string) string {
+	return channelKeyPrefix + channel
+}
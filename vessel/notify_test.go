@@ -0,0 +1,75 @@
+package vessel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that notify wakes up everything waiting on the key and only that
+// key.
+func TestNotifier(t *testing.T) {
+	assert := assert.New(t)
+	n := newNotifier()
+	foo1, done1 := n.wait("foo")
+	foo2, done2 := n.wait("foo")
+	bar, done3 := n.wait("bar")
+	defer done1()
+	defer done2()
+	defer done3()
+
+	n.notify("foo")
+
+	assert.True(closed(foo1))
+	assert.True(closed(foo2))
+	assert.False(closed(bar))
+}
+
+// Ensures that notifyChannel wakes up polls of the channel and of patterns
+// matching it.
+func TestNotifierChannel(t *testing.T) {
+	assert := assert.New(t)
+	n := newNotifier()
+	exact, done1 := n.wait(channelKey("orders.eu"))
+	pattern, done2 := n.wait(channelKey("orders.*"))
+	other, done3 := n.wait(channelKey("orders.us"))
+	result, done4 := n.wait(resultKey("orders.eu"))
+	defer done1()
+	defer done2()
+	defer done3()
+	defer done4()
+
+	n.notifyChannel("orders.eu")
+
+	assert.True(closed(exact))
+	assert.True(closed(pattern))
+	assert.False(closed(other))
+	assert.False(closed(result))
+}
+
+// Ensures that keys are forgotten once nothing is waiting on them.
+func TestNotifierDone(t *testing.T) {
+	assert := assert.New(t)
+	n := newNotifier()
+	_, done1 := n.wait("foo")
+	_, done2 := n.wait("foo")
+
+	done1()
+	assert.Len(n.waiters, 1)
+	done2()
+	assert.Len(n.waiters, 0)
+
+	_, done := n.wait("foo")
+	n.notify("foo")
+	done()
+	assert.Len(n.waiters, 0)
+}
+
+func closed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,86 @@
+package vessel
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// channelSeparator separates the tokens of hierarchical channel names,
+	// e.g. "orders.eu.new".
+	channelSeparator = "."
+
+	// anyToken matches exactly one token of a channel name.
+	anyToken = "*"
+
+	// restTokens matches one or more tokens when it's the last token of a
+	// pattern.
+	restTokens = ">"
+)
+
+// isPattern indicates if the channel name contains wildcards, so it names
+// every channel it matches rather than a single channel.
+func isPattern(channel string) bool {
+	tokens := strings.Split(channel, channelSeparator)
+	for i, token := range tokens {
+		if token == anyToken || (token == restTokens && i == len(tokens)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchChannel indicates if the channel matches the pattern. A "*" token
+// matches any single token and a trailing ">" matches one or more tokens, so
+// "orders.*" matches "orders.eu" and "orders.>" matches "orders.eu.new".
+// Patterns without wildcards only match the same channel.
+func MatchChannel(pattern, channel string) bool {
+	p := strings.Split(pattern, channelSeparator)
+	c := strings.Split(channel, channelSeparator)
+	for i, token := range p {
+		if token == restTokens && i == len(p)-1 {
+			return len(c) > i
+		}
+		if i >= len(c) || (token != anyToken && token != c[i]) {
+			return false
+		}
+	}
+	return len(p) == len(c)
+}
+
+// moreSpecific indicates if pattern a should be preferred over pattern b when
+// both match a channel. Comparing tokens from the left, the first literal
+// token beats "*", which beats ">".
+func moreSpecific(a, b string) bool {
+	at := strings.Split(a, channelSeparator)
+	bt := strings.Split(b, channelSeparator)
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		ra, rb := tokenRank(at[i], i == len(at)-1), tokenRank(bt[i], i == len(bt)-1)
+		if ra != rb {
+			return ra < rb
+		}
+	}
+	if len(at) != len(bt) {
+		return len(at) > len(bt)
+	}
+	return a < b
+}
+
+// tokenRank orders tokens from the most to the least specific.
+func tokenRank(token string, last bool) int {
+	switch {
+	case token == restTokens && last:
+		return 2
+	case token == anyToken:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// sortPatterns orders the patterns from the most to the least specific.
+func sortPatterns(patterns []string) {
+	sort.Slice(patterns, func(i, j int) bool {
+		return moreSpecific(patterns[i], patterns[j])
+	})
+}
@@ -0,0 +1,137 @@
+package vessel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Ensures that isPattern recognizes names with wildcard tokens.
+func TestIsPattern(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isPattern("orders.*"))
+	assert.True(isPattern("orders.*.new"))
+	assert.True(isPattern("orders.>"))
+	assert.True(isPattern(">"))
+	assert.False(isPattern("orders"))
+	assert.False(isPattern("orders.eu*"))
+	assert.False(isPattern("orders.>.new"))
+}
+
+// Ensures that MatchChannel matches single tokens with "*" and the remaining
+// tokens with a trailing ">".
+func TestMatchChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(MatchChannel("orders.eu", "orders.eu"))
+	assert.False(MatchChannel("orders.eu", "orders.us"))
+	assert.True(MatchChannel("orders.*", "orders.eu"))
+	assert.False(MatchChannel("orders.*", "orders.eu.new"))
+	assert.False(MatchChannel("orders.*", "orders"))
+	assert.True(MatchChannel("orders.*.new", "orders.eu.new"))
+	assert.False(MatchChannel("orders.*.new", "orders.eu.old"))
+	assert.True(MatchChannel("orders.>", "orders.eu"))
+	assert.True(MatchChannel("orders.>", "orders.eu.new"))
+	assert.False(MatchChannel("orders.>", "orders"))
+	assert.False(MatchChannel("orders.>", "users.eu"))
+	assert.True(MatchChannel(">", "orders"))
+}
+
+// Ensures that sortPatterns orders patterns from the most to the least
+// specific.
+func TestSortPatterns(t *testing.T) {
+	patterns := []string{"orders.>", ">", "orders.*.new", "orders.eu.*", "orders.*.>", "orders.*"}
+
+	sortPatterns(patterns)
+
+	assert.Equal(t, []string{"orders.eu.*", "orders.*.new", "orders.*.>", "orders.*", "orders.>", ">"}, patterns)
+}
+
+// Ensures that RecvRequest invokes the exact handler for a channel or else the most
+// specific pattern handler matching it.
+func TestRecvPattern(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVesselWithOptions("/vessel", &Options{Persister: new(mockPersister)})
+	handler := func(name string) Handler {
+		return func(ctx context.Context, req *Request, results chan<- string) {
+			results <- name
+		}
+	}
+	vessel.AddHandler("orders.>", handler("rest"))
+	vessel.AddHandler("orders.eu.*", handler("eu"))
+	vessel.AddHandler("orders.eu.new", handler("exact"))
+	vessel.AddHandler("orders.*.new", handler("new"))
+
+	recv := func(channel string) string {
+		results, _, err := vessel.RecvRequest(context.Background(), &Request{ID: "abc", Channel: channel})
+		if err != nil {
+			return err.Error()
+		}
+		return <-results
+	}
+
+	assert.Equal("exact", recv("orders.eu.new"))
+	assert.Equal("eu", recv("orders.eu.old"))
+	assert.Equal("new", recv("orders.us.new"))
+	assert.Equal("rest", recv("orders.us.old"))
+	assert.Equal("No channel registered for users.eu", recv("users.eu"))
+	assert.Equal("Cannot send to pattern orders.*", recv("orders.*"))
+}
+
+// Ensures that broadcasts are delivered once to sessions subscribed to
+// patterns matching the channel.
+func TestBroadcastPattern(t *testing.T) {
+	vessel := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	sessions := map[string]*mockSession{}
+	for _, id := range []string{"eu", "all", "us"} {
+		sessions[id] = addMockSession(vessel, id, nil)
+	}
+	vessel.sessions.get("eu").subscribe("orders.eu.*")
+	vessel.sessions.get("all").subscribe("orders.>")
+	vessel.sessions.get("all").subscribe("orders.eu.new")
+	vessel.sessions.get("us").subscribe("orders.us.*")
+
+	vessel.Broadcast("orders.eu.new", "hello")
+	vessel.Broadcast("orders.*", "hello")
+
+	sessions["eu"].AssertNumberOfCalls(t, "Send", 1)
+	sessions["all"].AssertNumberOfCalls(t, "Send", 1)
+	sessions["us"].AssertNotCalled(t, "Send", mock.Anything)
+}
+
+// Ensures that subscribing to a pattern replays the history of every channel
+// it matches which the session may subscribe to.
+func TestControlSubscribePatternReplay(t *testing.T) {
+	vessel := NewSockJSVesselWithOptions("/vessel", &Options{
+		Persister: NewMemoryPersister(0),
+		Authorizer: AuthorizerFunc(func(principal *Principal, action Action, channel string) bool {
+			return channel != "orders.us"
+		}),
+	}).(*sockjsVessel)
+	vessel.persister.SaveMessage("orders.eu", &Message{ID: "a", Channel: "orders.eu", Body: "a", Timestamp: 20})
+	vessel.persister.SaveMessage("orders.us", &Message{ID: "b", Channel: "orders.us", Body: "b", Timestamp: 10})
+	vessel.persister.SaveMessage("orders.uk", &Message{ID: "c", Channel: "orders.uk", Body: "c", Timestamp: 5})
+	vessel.persister.SaveMessage("orders.eu.new", &Message{ID: "d", Channel: "orders.eu.new", Body: "d", Timestamp: 30})
+	session := addMockSession(vessel, "s1", nil)
+
+	vessel.control(vessel.sessions.get("s1"), &Message{ID: "abc", Channel: subscribeChannel, Body: "orders.*", Timestamp: 1})
+
+	assert.Equal(t, []string{
+		`{"id":"c","channel":"orders.uk","body":"c","timestamp":5}`,
+		`{"id":"a","channel":"orders.eu","body":"a","timestamp":20}`,
+	}, sent(session))
+}
+
+// sent returns what was sent to the session in order.
+func sent(session *mockSession) []string {
+	messages := []string{}
+	for _, call := range session.Calls {
+		if call.Method == "Send" {
+			messages = append(messages, call.Arguments.String(0))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,205 @@
+package vessel
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const (
+	// PresenceJoin is the type of event emitted when a session subscribes to
+	// a channel.
+	PresenceJoin = "join"
+
+	// PresenceLeave is the type of event emitted when a session unsubscribes
+	// from a channel or disconnects.
+	PresenceLeave = "leave"
+
+	// presenceChannel is the reserved channel presence events are sent to
+	// the subscribers of a channel on. The body is the PresenceEvent as JSON.
+	presenceChannel = "_presence"
+
+	// envelopePresence is the kind of envelope carrying a presence event to
+	// the subscribers connected to other nodes.
+	envelopePresence = "presence"
+)
+
+// presenceHeartbeatInterval is how often a node tracking presence tells the
+// Persister it's still running. Its members are dropped if it misses three
+// heartbeats in a row, e.g. because it crashed.
+var presenceHeartbeatInterval = 10 * time.Second
+
+// PresenceConfig enables tracking which sessions are subscribed to each
+// channel.
+type PresenceConfig struct {
+	// Broadcast sends join and leave events to the subscribers of the
+	// channel they concern, on every node, as messages on the reserved
+	// _presence channel.
+	Broadcast bool
+
+	// OnEvent is called with each join and leave event if set. It's called
+	// on the node the session is connected to and must not block.
+	OnEvent func(*PresenceEvent)
+}
+
+// Member is a session subscribed to a channel.
+type Member struct {
+	// ID identifies the session's membership. It's generated by the server
+	// and unrelated to the session's ID, which clients could use to act as
+	// the session.
+	ID string `json:"id"`
+
+	// Node is the ID of the node the session is connected to.
+	Node string `json:"node,omitempty"`
+
+	// Principal is the ID of the Principal the session authenticated as, if
+	// any.
+	Principal string `json:"principal,omitempty"`
+
+	// Joined is when the session subscribed to the channel.
+	Joined int64 `json:"joined"`
+}
+
+// PresenceEvent reports that a session joined or left a channel.
+type PresenceEvent struct {
+	// Type is PresenceJoin or PresenceLeave.
+	Type string `json:"type"`
+
+	// Channel is the channel the session joined or left.
+	Channel string `json:"channel"`
+
+	// Member is the session which joined or left.
+	Member *Member `json:"member"`
+}
+
+// newMember returns the Member for the session connected to the node which
+// subscribed to a channel at the time.
+func newMember(node string, session *session, joined time.Time) *Member {
+	member := &Member{ID: session.memberID, Node: node, Joined: joined.Unix()}
+	if principal := session.getPrincipal(); principal != nil {
+		member.Principal = principal.ID
+	}
+	return member
+}
+
+// Members returns the sessions subscribed to the channel on every node
+// sharing the Persister, in the order they joined. It's empty unless
+// presence is enabled.
+func (s *sockjsVessel) Members(channel string) ([]*Member, error) {
+	persister, ok := s.persister.(PresencePersister)
+	if !ok {
+		return []*Member{}, nil
+	}
+	members, err := persister.GetMembers(channel)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Joined != members[j].Joined {
+			return members[i].Joined < members[j].Joined
+		}
+		return members[i].ID < members[j].ID
+	})
+	return members, nil
+}
+
+// join records that the session subscribed to the channel at the time.
+// Subscriptions to patterns aren't tracked.
+func (s *sockjsVessel) join(session *session, channel string, joined time.Time) {
+	if s.presence == nil || isPattern(channel) {
+		return
+	}
+	member := newMember(s.node, session, joined)
+	if err := s.persister.(PresencePersister).AddMember(channel, member); err != nil {
+		s.log.errorf("Failed to add member %s to %s: %s", member.ID, channel, err)
+	}
+	s.emitPresence(&PresenceEvent{Type: PresenceJoin, Channel: channel, Member: member})
+}
+
+// leave records that the session which subscribed to the channel at the time
+// has left it.
+func (s *sockjsVessel) leave(session *session, channel string, joined time.Time) {
+	if s.presence == nil || isPattern(channel) {
+		return
+	}
+	member := newMember(s.node, session, joined)
+	if err := s.persister.(PresencePersister).RemoveMember(channel, member.ID); err != nil {
+		s.log.errorf("Failed to remove member %s from %s: %s", member.ID, channel, err)
+	}
+	s.emitPresence(&PresenceEvent{Type: PresenceLeave, Channel: channel, Member: member})
+}
+
+// leaveAll unsubscribes the session from every channel, recording that it has
+// left them. It's called when the session disconnects.
+func (s *sockjsVessel) leaveAll(session *session) {
+	for channel, joined := range session.unsubscribeAll() {
+		s.leave(session, channel, joined)
+	}
+}
+
+// heartbeat records that the node is running so its members are kept until
+// it misses three heartbeats sent at the interval.
+func (s *sockjsVessel) heartbeat(interval time.Duration) error {
+	return s.persister.(PresencePersister).Heartbeat(s.node, 3*interval)
+}
+
+// heartbeats records that the node is running at the interval until the
+// Vessel is shut down.
+func (s *sockjsVessel) heartbeats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.heartbeat(interval); err != nil {
+				s.log.errorf("Failed to record heartbeat: %s", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// emitPresence passes the event to the OnEvent hook and broadcasts it to the
+// channel's subscribers if configured.
+func (s *sockjsVessel) emitPresence(event *PresenceEvent) {
+	if s.presence.OnEvent != nil {
+		s.presence.OnEvent(event)
+	}
+	if !s.presence.Broadcast {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		s.log.errorf("Failed to marshal presence event on %s: %s", event.Channel, err)
+		return
+	}
+	msg := s.messageGenerator(s.idGenerator(), presenceChannel, string(body))
+	s.deliverPresence(event.Channel, msg)
+	if s.backplane != nil {
+		s.publish(&envelope{Kind: envelopePresence, Channel: event.Channel, Message: msg})
+	}
+}
+
+// deliverPresence sends a presence event message to the sessions connected to
+// this node which are subscribed to the channel.
+func (s *sockjsVessel) deliverPresence(channel string, msg *Message) {
+	send, err := s.marshaler.Marshal(msg)
+	if err != nil {
+		s.log.errorf("Failed to marshal presence event on %s: %s", channel, err)
+		return
+	}
+
+	sendStr := string(send)
+	for _, session := range s.sessions.all() {
+		if !session.subscribed(channel) {
+			continue
+		}
+		if !s.authorize(session.getPrincipal(), ActionSubscribe, channel) {
+			continue
+		}
+		s.log.debugf("Send %s", sendStr)
+		session.Send(sendStr)
+	}
+}
@@ -0,0 +1,173 @@
+package vessel
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newPresenceVessel returns a Vessel tracking presence which records the
+// presence events it emits.
+func newPresenceVessel(broadcast bool) (*sockjsVessel, *[]*PresenceEvent) {
+	events := []*PresenceEvent{}
+	v := NewSockJSVesselWithOptions("/vessel", &Options{
+		Persister: NewMemoryPersister(0),
+		Presence: &PresenceConfig{
+			Broadcast: broadcast,
+			OnEvent: func(event *PresenceEvent) {
+				events = append(events, event)
+			},
+		},
+	}).(*sockjsVessel)
+	return v, &events
+}
+
+// Ensures that subscribing and unsubscribing join and leave the channel once.
+func TestPresenceSubscribe(t *testing.T) {
+	assert := assert.New(t)
+	v, events := newPresenceVessel(false)
+	addMockSession(v, "s1", &Principal{ID: "user"})
+	session := v.sessions.get("s1")
+
+	v.control(session, &Message{ID: "a", Channel: subscribeChannel, Body: "foo"})
+	v.control(session, &Message{ID: "b", Channel: subscribeChannel, Body: "foo"})
+
+	members, err := v.Members("foo")
+	assert.Nil(err)
+	if assert.Len(members, 1) {
+		assert.Equal(session.memberID, members[0].ID)
+		assert.NotEqual("s1", members[0].ID)
+		assert.Equal("user", members[0].Principal)
+		assert.Equal(v.node, members[0].Node)
+	}
+
+	v.control(session, &Message{ID: "c", Channel: unsubscribeChannel, Body: "foo"})
+	v.control(session, &Message{ID: "d", Channel: unsubscribeChannel, Body: "foo"})
+
+	members, _ = v.Members("foo")
+	assert.Len(members, 0)
+	if assert.Len(*events, 2) {
+		assert.Equal(PresenceJoin, (*events)[0].Type)
+		assert.Equal(PresenceLeave, (*events)[1].Type)
+		assert.Equal("foo", (*events)[1].Channel)
+		assert.Equal((*events)[0].Member.Joined, (*events)[1].Member.Joined)
+	}
+}
+
+// Ensures that a session leaves every channel it's subscribed to when it
+// disconnects.
+func TestPresenceLeaveAll(t *testing.T) {
+	assert := assert.New(t)
+	v, events := newPresenceVessel(false)
+	addMockSession(v, "s1", nil)
+	session := v.sessions.get("s1")
+	v.control(session, &Message{ID: "a", Channel: subscribeChannel, Body: "foo"})
+	v.control(session, &Message{ID: "b", Channel: subscribeChannel, Body: "bar"})
+
+	v.leaveAll(session)
+	v.leaveAll(session)
+
+	foo, _ := v.Members("foo")
+	bar, _ := v.Members("bar")
+	assert.Len(foo, 0)
+	assert.Len(bar, 0)
+	assert.Len(*events, 4)
+	assert.False(session.subscribed("foo"))
+}
+
+// Ensures that Prepare starts sending heartbeats so the node's members are
+// kept.
+func TestPresenceHeartbeat(t *testing.T) {
+	interval := presenceHeartbeatInterval
+	presenceHeartbeatInterval = 10 * time.Millisecond
+	defer func() { presenceHeartbeatInterval = interval }()
+	persister := new(mockPersister)
+	v := NewSockJSVesselWithOptions("/vessel", &Options{
+		Persister: persister,
+		Presence:  &PresenceConfig{},
+	}).(*sockjsVessel)
+	beats := make(chan bool, 10)
+	persister.On("Prepare").Return(nil)
+	persister.On("Heartbeat", v.node, 30*time.Millisecond).Return(nil).Run(func(mock.Arguments) {
+		select {
+		case beats <- true:
+		default:
+		}
+	})
+	persister.On("Close").Return(nil)
+
+	assert.Nil(t, v.Prepare())
+	for i := 0; i < 2; i++ {
+		select {
+		case <-beats:
+		case <-time.After(time.Second):
+			t.Fatal("Expected heartbeat")
+		}
+	}
+	v.Shutdown(context.Background())
+}
+
+// Ensures that subscriptions to patterns aren't tracked.
+func TestPresencePattern(t *testing.T) {
+	v, events := newPresenceVessel(false)
+	addMockSession(v, "s1", nil)
+	session := v.sessions.get("s1")
+
+	v.control(session, &Message{ID: "a", Channel: subscribeChannel, Body: "foo.*"})
+	v.leaveAll(session)
+
+	members, _ := v.Members("foo.*")
+	assert.Len(t, members, 0)
+	assert.Len(t, *events, 0)
+}
+
+// Ensures that members aren't tracked unless presence is enabled.
+func TestPresenceDisabled(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	addMockSession(v, "s1", nil)
+
+	v.control(v.sessions.get("s1"), &Message{ID: "a", Channel: subscribeChannel, Body: "foo"})
+
+	members, err := v.Members("foo")
+	assert.Nil(t, err)
+	assert.Len(t, members, 0)
+}
+
+// Ensures that presence events are broadcast to the channel's subscribers on
+// every node on the _presence channel.
+func TestPresenceBroadcast(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewMemoryBus()
+	node1 := NewSockJSVesselWithOptions("/vessel", &Options{
+		Persister: NewMemoryPersister(0),
+		Backplane: bus.Backplane(),
+		Presence:  &PresenceConfig{Broadcast: true},
+	}).(*sockjsVessel)
+	node1.Prepare()
+	_, subscriber := newNode(t, bus, "foo")
+	_, other := newNode(t, bus, "bar")
+	session := addMockSession(node1, "s1", nil)
+
+	node1.control(node1.sessions.get("s1"), &Message{ID: "a", Channel: subscribeChannel, Body: "foo"})
+
+	session.AssertNumberOfCalls(t, "Send", 1)
+	other.AssertNotCalled(t, "Send", mock.Anything)
+	subscriber.AssertNumberOfCalls(t, "Send", 1)
+	for _, call := range subscriber.Calls {
+		if call.Method != "Send" {
+			continue
+		}
+		var msg Message
+		assert.Nil(json.Unmarshal([]byte(call.Arguments.String(0)), &msg))
+		assert.Equal(presenceChannel, msg.Channel)
+		var event PresenceEvent
+		assert.Nil(json.Unmarshal([]byte(msg.Body), &event))
+		assert.Equal(PresenceJoin, event.Type)
+		assert.Equal("foo", event.Channel)
+		assert.Equal(node1.sessions.get("s1").memberID, event.Member.ID)
+	}
+}
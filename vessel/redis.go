@@ -2,64 +2,330 @@ package vessel
 
 import (
 	"encoding/json"
+	"errors"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
+const (
+	defaultRedisAddr           = ":6379"
+	defaultRedisMaxIdle        = 10
+	defaultRedisIdleTimeout    = 4 * time.Minute
+	defaultRedisConnectTimeout = 5 * time.Second
+
+	// redisResultPrefix and redisHistoryPrefix prefix the keys of results by
+	// message ID and of the sorted sets of messages on each channel. Both are
+	// chosen by clients, so they're kept apart from the other keys Vessel
+	// uses.
+	redisResultPrefix  = "vessel:result:"
+	redisHistoryPrefix = "vessel:history:"
+
+	// redisChannelsKey is the set of channels with history. It's used to
+	// apply the retention policy and to find the channels matching patterns
+	// which start with a wildcard.
+	redisChannelsKey = "vessel:channels"
+
+	// redisChannelIndexPrefix prefixes the keys of the sets of channels with
+	// history by their first token, e.g. "vessel:channels:orders" holds
+	// "orders.eu" and "orders.us", so patterns only scan the channels they
+	// could match.
+	redisChannelIndexPrefix = "vessel:channels:"
+
+	// redisQueuePrefix and redisQueueMessagesPrefix prefix the keys of the
+	// sorted sets of the IDs of messages queued for users who aren't
+	// connected and of the hashes of the messages themselves.
+	redisQueuePrefix         = "vessel:queue:"
+	redisQueueMessagesPrefix = "vessel:queued:"
+
+	// redisPresencePrefix prefixes the keys of the hashes of members
+	// subscribed to each channel.
+	redisPresencePrefix = "vessel:presence:"
+
+	// redisNodePrefix prefixes the keys which exist while the node with the
+	// ID is sending presence heartbeats.
+	redisNodePrefix = "vessel:node:"
+
+	// redisHealthCheckInterval is how long a connection may sit idle in the
+	// pool before it's checked with a PING when borrowed.
+	redisHealthCheckInterval = time.Minute
+
+	// defaultRedisBackplaneChannel is the Pub/Sub channel the Redis Backplane
+	// relays messages on.
+	defaultRedisBackplaneChannel = "vessel:backplane"
+
+	// redisResubscribeWait is how long the Redis Backplane waits before
+	// resubscribing after losing its connection. It doubles after each failed
+	// attempt up to redisMaxResubscribeWait.
+	redisResubscribeWait    = 100 * time.Millisecond
+	redisMaxResubscribeWait = 10 * time.Second
+)
+
+// errBackplaneClosed is returned when subscribing to a closed Backplane.
+var errBackplaneClosed = errors.New("Backplane closed")
+
+// RedisConfig configures the connections used by the Redis Persister and
+// Backplane.
+type RedisConfig struct {
+	// Addr is the address of the Redis server. Defaults to ":6379".
+	Addr string
+
+	// Password is used to authenticate with Redis if set.
+	Password string
+
+	// DB is the database selected after connecting.
+	DB int
+
+	// MaxIdle is the maximum number of idle connections kept in the pool.
+	// Defaults to 10.
+	MaxIdle int
+
+	// MaxActive is the maximum number of connections open at once. When the
+	// limit is reached, callers wait for a connection to be returned. Zero
+	// means no limit.
+	MaxActive int
+
+	// IdleTimeout closes connections which have been idle for longer than
+	// the duration. Defaults to four minutes.
+	IdleTimeout time.Duration
+
+	// ConnectTimeout limits how long dialing Redis may take. Defaults to five
+	// seconds.
+	ConnectTimeout time.Duration
+
+	// ReadTimeout and WriteTimeout limit how long a single command may take.
+	// Zero means no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// pool provides connections to Redis. It's satisfied by *redis.Pool.
+type pool interface {
+	Get() redis.Conn
+	Close() error
+}
+
 type redisPersister struct {
-	conn redis.Conn
-	mu   sync.RWMutex
+	config    RedisConfig
+	pool      pool
+	retention RetentionPolicy
 }
 
+// NewPersister returns a new Persister backed by Redis on the default port.
 func NewPersister() Persister {
-	return &redisPersister{mu: sync.RWMutex{}}
+	return NewRedisPersister(nil)
+}
+
+// NewRedisPersister returns a new Persister backed by the Redis server
+// described by config. A nil config uses the defaults.
+func NewRedisPersister(config *RedisConfig) Persister {
+	return &redisPersister{config: config.withDefaults()}
+}
+
+// withDefaults returns a copy of the config with unset fields replaced by
+// their default values. It may be called on a nil config.
+func (c *RedisConfig) withDefaults() RedisConfig {
+	config := RedisConfig{}
+	if c != nil {
+		config = *c
+	}
+	if config.Addr == "" {
+		config.Addr = defaultRedisAddr
+	}
+	if config.MaxIdle == 0 {
+		config.MaxIdle = defaultRedisMaxIdle
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = defaultRedisIdleTimeout
+	}
+	if config.ConnectTimeout == 0 {
+		config.ConnectTimeout = defaultRedisConnectTimeout
+	}
+	return config
 }
 
+// newPool returns a connection pool for the Redis server described by the
+// config.
+func (c RedisConfig) newPool() *redis.Pool {
+	return &redis.Pool{
+		Dial:         c.dial,
+		TestOnBorrow: testOnBorrow,
+		MaxIdle:      c.MaxIdle,
+		MaxActive:    c.MaxActive,
+		IdleTimeout:  c.IdleTimeout,
+		Wait:         c.MaxActive > 0,
+	}
+}
+
+// Prepare creates the connection pool and verifies that Redis is reachable.
+// Broken connections are discarded by the pool and replaced on demand, so a
+// lost connection doesn't require calling Prepare again.
 func (r *redisPersister) Prepare() error {
-	c, err := redis.Dial("tcp", ":6379")
+	if r.pool == nil {
+		r.pool = r.config.newPool()
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
+// dial opens a new connection to Redis, authenticating and selecting the
+// configured database.
+func (c RedisConfig) dial() (redis.Conn, error) {
+	conn, err := redis.DialTimeout("tcp", c.Addr,
+		c.ConnectTimeout, c.ReadTimeout, c.WriteTimeout)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	r.conn = c
-	return nil
+
+	if c.Password != "" {
+		if _, err := conn.Do("AUTH", c.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if c.DB != 0 {
+		if _, err := conn.Do("SELECT", c.DB); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
 }
 
-func (r *redisPersister) SaveResult(id string, result *result) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// testOnBorrow checks the health of connections which have been idle for a
+// while before they're handed out by the pool.
+func testOnBorrow(c redis.Conn, t time.Time) error {
+	if time.Since(t) < redisHealthCheckInterval {
+		return nil
+	}
+	_, err := c.Do("PING")
+	return err
+}
+
+// SetRetention sets the RetentionPolicy. Results expire using Redis key
+// expiry while channel history is trimmed on write and by Trim.
+func (r *redisPersister) SetRetention(retention RetentionPolicy) {
+	r.retention = retention
+}
+
+func (r *redisPersister) SaveResult(id string, result *Result) error {
+	conn := r.pool.Get()
+	defer conn.Close()
 
 	resultJSON, err := json.Marshal(result)
 	if err != nil {
 		return err
 	}
-	_, err = r.conn.Do("SET", id, resultJSON)
+
+	if r.retention.ResultTTL > 0 {
+		_, err = conn.Do("SET", redisResultPrefix+id, resultJSON, "EX", seconds(r.retention.ResultTTL))
+	} else {
+		_, err = conn.Do("SET", redisResultPrefix+id, resultJSON)
+	}
 	return err
 }
 
-func (r *redisPersister) SaveMessage(channel string, message *message) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *redisPersister) SaveMessage(channel string, message *Message) error {
+	conn := r.pool.Get()
+	defer conn.Close()
 
 	resultJSON, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
-	_, err = r.conn.Do("ZADD", channel, message.Timestamp, resultJSON)
-	return err
+	if _, err = conn.Do("ZADD", redisHistoryPrefix+channel, message.Timestamp, resultJSON); err != nil {
+		return err
+	}
+	if _, err := conn.Do("SADD", redisChannelsKey, channel); err != nil {
+		return err
+	}
+	if _, err := conn.Do("SADD", redisChannelIndexKey(channel), channel); err != nil {
+		return err
+	}
+
+	if !r.retention.limitsMessages() {
+		return nil
+	}
+	return r.trimChannel(conn, channel)
 }
 
-func (r *redisPersister) GetResult(id string) (*result, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// Trim removes messages outside the retention policy from every channel with
+// history. Channels which no longer have any messages are forgotten.
+func (r *redisPersister) Trim() error {
+	if !r.retention.limitsMessages() {
+		return nil
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
 
-	resultJSON, err := redis.String(r.conn.Do("GET", id))
+	channels, err := redis.Strings(conn.Do("SMEMBERS", redisChannelsKey))
+	if err != nil {
+		return err
+	}
+
+	for _, channel := range channels {
+		if err := r.trimChannel(conn, channel); err != nil {
+			return err
+		}
+
+		exists, err := redis.Bool(conn.Do("EXISTS", redisHistoryPrefix+channel))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := conn.Do("SREM", redisChannelIndexKey(channel), channel); err != nil {
+				return err
+			}
+			if _, err := conn.Do("SREM", redisChannelsKey, channel); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// trimChannel removes messages outside the retention policy from the channel.
+func (r *redisPersister) trimChannel(conn redis.Conn, channel string) error {
+	if r.retention.MessageTTL > 0 {
+		cutoff := time.Now().Add(-r.retention.MessageTTL).Unix()
+		max := "(" + strconv.FormatInt(cutoff, 10)
+		if _, err := conn.Do("ZREMRANGEBYSCORE", redisHistoryPrefix+channel, "-inf", max); err != nil {
+			return err
+		}
+		if _, err := conn.Do("EXPIRE", redisHistoryPrefix+channel, seconds(r.retention.MessageTTL)); err != nil {
+			return err
+		}
+	}
+
+	if r.retention.MaxMessages > 0 {
+		if _, err := conn.Do("ZREMRANGEBYRANK", redisHistoryPrefix+channel, 0, -(r.retention.MaxMessages + 1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *redisPersister) GetResult(id string) (*Result, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	resultJSON, err := redis.String(conn.Do("GET", redisResultPrefix+id))
 	if err != nil {
 		return nil, err
 	}
 
-	var result result
+	var result Result
 	if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
 		return nil, err
 	}
@@ -67,23 +333,427 @@ func (r *redisPersister) GetResult(id string) (*result, error) {
 	return &result, nil
 }
 
-func (r *redisPersister) GetMessages(channel string, since int64) ([]*message, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// GetMessages returns the messages on the channel since the timestamp,
+// skipping messages which have outlived the retention policy but haven't been
+// trimmed yet. If the channel is a pattern, the messages on every channel with
+// history which it matches are read in a single transaction and returned in
+// timestamp order.
+func (r *redisPersister) GetMessages(channel string, since int64) ([]*Message, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
 
+	if r.retention.MessageTTL > 0 {
+		if cutoff := time.Now().Add(-r.retention.MessageTTL).Unix() - 1; cutoff > since {
+			since = cutoff
+		}
+	}
 	min := "(" + strconv.FormatInt(since, 10)
-	max := "+inf"
-	messages, err := redis.Strings(r.conn.Do("ZRANGEBYSCORE", channel, min, max))
+	if !isPattern(channel) {
+		return unmarshalMessages(redis.Strings(conn.Do("ZRANGEBYSCORE", redisHistoryPrefix+channel, min, "+inf")))
+	}
+
+	index := redisChannelsKey
+	if first := strings.SplitN(channel, channelSeparator, 2)[0]; first != anyToken && first != restTokens {
+		index = redisChannelIndexKey(channel)
+	}
+	channels, err := redis.Strings(conn.Do("SMEMBERS", index))
 	if err != nil {
 		return nil, err
 	}
+	matched := []string{}
+	for _, name := range channels {
+		if MatchChannel(channel, name) {
+			matched = append(matched, name)
+		}
+	}
+	if len(matched) == 0 {
+		return []*Message{}, nil
+	}
 
-	m := make([]*message, len(messages))
-	for i, msg := range messages {
-		if err := json.Unmarshal([]byte(msg), &m[i]); err != nil {
+	conn.Send("MULTI")
+	for _, name := range matched {
+		conn.Send("ZRANGEBYSCORE", redisHistoryPrefix+name, min, "+inf")
+	}
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	messages := []*Message{}
+	for _, reply := range replies {
+		channelMessages, err := unmarshalMessages(redis.Strings(reply, nil))
+		if err != nil {
 			return nil, err
 		}
+		messages = append(messages, channelMessages...)
+	}
+	sortMessages(messages)
+	return messages, nil
+}
+
+// unmarshalMessages decodes the messages read from a channel's sorted set.
+func unmarshalMessages(values []string, err error) ([]*Message, error) {
+	if err != nil {
+		return nil, err
 	}
 
-	return m, nil
+	messages := make([]*Message, len(values))
+	for i, value := range values {
+		if err := json.Unmarshal([]byte(value), &messages[i]); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// redisChannelIndexKey returns the key of the set of channels sharing the
+// channel's first token.
+func redisChannelIndexKey(channel string) string {
+	return redisChannelIndexPrefix + strings.SplitN(channel, channelSeparator, 2)[0]
+}
+
+// QueueMessage stores the message in a hash of the user's queued messages by
+// ID, indexed by a sorted set of their IDs scored by timestamp. At most the
+// retention policy's MaxMessages, or 1000 if it's unset, are queued,
+// discarding the oldest first. The queue expires once its newest message has
+// outlived the retention policy's MessageTTL, if any.
+func (r *redisPersister) QueueMessage(user string, message *Message) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	messagesKey, indexKey := redisQueueMessagesKey(user), redisQueueKey(user)
+	if _, err := conn.Do("HSET", messagesKey, message.ID, messageJSON); err != nil {
+		return err
+	}
+	if _, err := conn.Do("ZADD", indexKey, message.Timestamp, message.ID); err != nil {
+		return err
+	}
+
+	maxMessages := r.retention.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = defaultMaxMessages
+	}
+	discarded, err := redis.Strings(conn.Do("ZRANGE", indexKey, 0, -(maxMessages + 1)))
+	if err != nil {
+		return err
+	}
+	if len(discarded) > 0 {
+		if _, err := conn.Do("ZREM", redis.Args{}.Add(indexKey).AddFlat(discarded)...); err != nil {
+			return err
+		}
+		if _, err := conn.Do("HDEL", redis.Args{}.Add(messagesKey).AddFlat(discarded)...); err != nil {
+			return err
+		}
+	}
+
+	if r.retention.MessageTTL > 0 {
+		if _, err := conn.Do("EXPIRE", messagesKey, seconds(r.retention.MessageTTL)); err != nil {
+			return err
+		}
+		if _, err := conn.Do("EXPIRE", indexKey, seconds(r.retention.MessageTTL)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteQueued removes the message with the ID from the user's queue.
+func (r *redisPersister) DeleteQueued(user, id string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("ZREM", redisQueueKey(user), id); err != nil {
+		return err
+	}
+	_, err := conn.Do("HDEL", redisQueueMessagesKey(user), id)
+	return err
+}
+
+// TakeQueued reads and deletes the user's queued messages in a transaction so
+// each is taken once, skipping messages which have outlived the retention
+// policy.
+func (r *redisPersister) TakeQueued(user string) ([]*Message, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	messagesKey := redisQueueMessagesKey(user)
+	conn.Send("MULTI")
+	conn.Send("HVALS", messagesKey)
+	conn.Send("DEL", messagesKey, redisQueueKey(user))
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	values, err := redis.Strings(replies[0], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var cutoff int64
+	if r.retention.MessageTTL > 0 {
+		cutoff = time.Now().Add(-r.retention.MessageTTL).Unix()
+	}
+	messages := make([]*Message, 0, len(values))
+	for _, value := range values {
+		var message *Message
+		if err := json.Unmarshal([]byte(value), &message); err != nil {
+			return nil, err
+		}
+		if message.Timestamp >= cutoff {
+			messages = append(messages, message)
+		}
+	}
+	sortMessages(messages)
+	return messages, nil
+}
+
+// redisQueueKey returns the key of the sorted set of the IDs of the messages
+// queued for the user, scored by timestamp.
+func redisQueueKey(user string) string {
+	return redisQueuePrefix + user
+}
+
+// redisQueueMessagesKey returns the key of the hash of the messages queued for
+// the user by ID.
+func redisQueueMessagesKey(user string) string {
+	return redisQueueMessagesPrefix + user
+}
+
+// AddMember stores the member in a hash of the channel's members by ID.
+func (r *redisPersister) AddMember(channel string, member *Member) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	memberJSON, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", redisPresencePrefix+channel, member.ID, memberJSON)
+	return err
+}
+
+// RemoveMember removes the member with the ID from the channel's presence
+// hash.
+func (r *redisPersister) RemoveMember(channel, session string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("HDEL", redisPresencePrefix+channel, session)
+	return err
+}
+
+// GetMembers reads the members from the channel's presence hash. Members of
+// nodes whose heartbeat key has expired are removed from the hash and left
+// out.
+func (r *redisPersister) GetMembers(channel string) ([]*Member, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	key := redisPresencePrefix + channel
+	values, err := redis.Strings(conn.Do("HVALS", key))
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*Member, 0, len(values))
+	alive := map[string]bool{}
+	for _, value := range values {
+		var member *Member
+		if err := json.Unmarshal([]byte(value), &member); err != nil {
+			return nil, err
+		}
+		if member.Node != "" {
+			if _, ok := alive[member.Node]; !ok {
+				exists, err := redis.Bool(conn.Do("EXISTS", redisNodePrefix+member.Node))
+				if err != nil {
+					return nil, err
+				}
+				alive[member.Node] = exists
+			}
+			if !alive[member.Node] {
+				if _, err := conn.Do("HDEL", key, member.ID); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Heartbeat sets a key for the node which expires after the duration.
+func (r *redisPersister) Heartbeat(node string, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", redisNodePrefix+node, 1, "EX", seconds(ttl))
+	return err
+}
+
+// Close closes the connection pool. Writes are sent synchronously, so there's
+// nothing to flush.
+func (r *redisPersister) Close() error {
+	if r.pool == nil {
+		return nil
+	}
+	return r.pool.Close()
+}
+
+// seconds converts the duration to whole seconds for Redis, rounding up so
+// that short durations don't disable expiry.
+func seconds(d time.Duration) int64 {
+	secs := int64(d / time.Second)
+	if d%time.Second != 0 {
+		secs++
+	}
+	return secs
+}
+
+type redisBackplane struct {
+	config  RedisConfig
+	channel string
+	pool    pool
+	dial    func() (redis.Conn, error)
+	conn    redis.Conn
+	closed  bool
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+// NewRedisBackplane returns a new Backplane which relays messages through
+// Redis Pub/Sub on the channel, or "vessel:backplane" if it's empty. A nil
+// config uses the defaults. Messages published while a node is reconnecting
+// to Redis aren't delivered to it, but remain in channel history for clients
+// to resume from.
+func NewRedisBackplane(config *RedisConfig, channel string) Backplane {
+	if channel == "" {
+		channel = defaultRedisBackplaneChannel
+	}
+	c := config.withDefaults()
+	// Subscriptions sit idle until a message is published, so reads on them
+	// mustn't time out.
+	subscriber := c
+	subscriber.ReadTimeout = 0
+	return &redisBackplane{
+		config:  c,
+		channel: channel,
+		dial:    subscriber.dial,
+		done:    make(chan struct{}),
+	}
+}
+
+// Publish publishes the data on the Redis channel.
+func (r *redisBackplane) Publish(data []byte) error {
+	conn := r.getPool().Get()
+	defer conn.Close()
+	_, err := conn.Do("PUBLISH", r.channel, data)
+	return err
+}
+
+// Subscribe subscribes to the Redis channel on a dedicated connection and
+// calls the function with each message published on it. If the connection
+// is lost, it resubscribes until the Backplane is closed.
+func (r *redisBackplane) Subscribe(handler func([]byte)) error {
+	conn, err := r.subscribe()
+	if err != nil {
+		return err
+	}
+	go r.receive(conn, handler)
+	return nil
+}
+
+// Close closes the subscription and the connection pool.
+func (r *redisBackplane) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	conn := r.conn
+	p := r.pool
+	r.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	if p == nil {
+		return nil
+	}
+	return p.Close()
+}
+
+func (r *redisBackplane) getPool() pool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pool == nil {
+		r.pool = r.config.newPool()
+	}
+	return r.pool
+}
+
+// subscribe opens a connection subscribed to the Redis channel.
+func (r *redisBackplane) subscribe() (redis.Conn, error) {
+	conn, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	if err := (redis.PubSubConn{Conn: conn}).Subscribe(r.channel); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		conn.Close()
+		return nil, errBackplaneClosed
+	}
+	r.conn = conn
+	return conn, nil
+}
+
+// receive calls the handler with each message received on the subscribed
+// connection, resubscribing whenever it's lost, until the Backplane is closed.
+func (r *redisBackplane) receive(conn redis.Conn, handler func([]byte)) {
+	for {
+		switch reply := (redis.PubSubConn{Conn: conn}).Receive().(type) {
+		case redis.Message:
+			handler(reply.Data)
+		case error:
+			conn.Close()
+			if conn = r.resubscribe(); conn == nil {
+				return
+			}
+		}
+	}
+}
+
+// resubscribe waits and subscribes again until it succeeds or the Backplane
+// is closed, in which case it returns nil.
+func (r *redisBackplane) resubscribe() redis.Conn {
+	wait := redisResubscribeWait
+	for {
+		select {
+		case <-time.After(wait):
+		case <-r.done:
+			return nil
+		}
+
+		conn, err := r.subscribe()
+		if err == nil {
+			return conn
+		}
+		if err == errBackplaneClosed {
+			return nil
+		}
+		if wait *= 2; wait > redisMaxResubscribeWait {
+			wait = redisMaxResubscribeWait
+		}
+	}
 }
@@ -3,8 +3,12 @@ package vessel
 import (
 	"encoding/json"
 	"fmt"
-	"sync"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -44,14 +48,29 @@ func (m *mockConn) Receive() (interface{}, error) {
 	return args.Get(0), args.Error(1)
 }
 
+type mockPool struct {
+	conn   redis.Conn
+	closed bool
+}
+
+func (m *mockPool) Get() redis.Conn {
+	return m.conn
+}
+
+func (m *mockPool) Close() error {
+	m.closed = true
+	return nil
+}
+
 // Ensures that SaveResult performs a SET operation on redis and returns nil on
 // success.
 func TestSaveResult(t *testing.T) {
 	mockConn := new(mockConn)
-	r := &redisPersister{mu: sync.RWMutex{}, conn: mockConn}
-	result := &result{Done: false, Responses: []*message{}}
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	result := &Result{Done: false, Responses: []*Message{}}
 	resultJSON, _ := json.Marshal(result)
-	args := []interface{}{"abc", resultJSON}
+	args := []interface{}{"vessel:result:abc", resultJSON}
 	mockConn.On("Do", "SET", args).Return(nil, nil)
 
 	err := r.SaveResult("abc", result)
@@ -64,10 +83,11 @@ func TestSaveResult(t *testing.T) {
 // error on fail.
 func TestSaveResultError(t *testing.T) {
 	mockConn := new(mockConn)
-	r := &redisPersister{mu: sync.RWMutex{}, conn: mockConn}
-	result := &result{Done: false, Responses: []*message{}}
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	result := &Result{Done: false, Responses: []*Message{}}
 	resultJSON, _ := json.Marshal(result)
-	args := []interface{}{"abc", resultJSON}
+	args := []interface{}{"vessel:result:abc", resultJSON}
 	mockConn.On("Do", "SET", args).Return(nil, fmt.Errorf("error"))
 
 	err := r.SaveResult("abc", result)
@@ -76,15 +96,18 @@ func TestSaveResultError(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
-// Ensures that SaveMessage performs a ZADD operation on redis and returns nil
-// on success.
+// Ensures that SaveMessage performs a ZADD operation on redis, records the
+// channel and returns nil on success.
 func TestSaveMessage(t *testing.T) {
 	mockConn := new(mockConn)
-	r := &redisPersister{mu: sync.RWMutex{}, conn: mockConn}
-	message := &message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412006603}
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	message := &Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412006603}
 	messageJSON, _ := json.Marshal(message)
-	args := []interface{}{"foo", 1412006603, messageJSON}
+	args := []interface{}{"vessel:history:foo", int64(1412006603), messageJSON}
 	mockConn.On("Do", "ZADD", args).Return(nil, nil)
+	mockConn.On("Do", "SADD", []interface{}{redisChannelsKey, "foo"}).Return(int64(1), nil)
+	mockConn.On("Do", "SADD", []interface{}{"vessel:channels:foo", "foo"}).Return(int64(1), nil)
 
 	err := r.SaveMessage("foo", message)
 
@@ -96,10 +119,11 @@ func TestSaveMessage(t *testing.T) {
 // error on fail.
 func TestSaveMessageError(t *testing.T) {
 	mockConn := new(mockConn)
-	r := &redisPersister{mu: sync.RWMutex{}, conn: mockConn}
-	message := &message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412006603}
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	message := &Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412006603}
 	messageJSON, _ := json.Marshal(message)
-	args := []interface{}{"foo", 1412006603, messageJSON}
+	args := []interface{}{"vessel:history:foo", int64(1412006603), messageJSON}
 	mockConn.On("Do", "ZADD", args).Return(nil, fmt.Errorf("error"))
 
 	err := r.SaveMessage("foo", message)
@@ -113,9 +137,10 @@ func TestSaveMessageError(t *testing.T) {
 func GetResult(t *testing.T) {
 	assert := assert.New(t)
 	mockConn := new(mockConn)
-	r := &redisPersister{mu: sync.RWMutex{}, conn: mockConn}
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
 	var res interface{}
-	res, _ = json.Marshal(&result{Done: false, Responses: []*message{}})
+	res, _ = json.Marshal(&Result{Done: false, Responses: []*Message{}})
 	mockConn.On("Do", "GET", "abc").Return(res, nil)
 
 	msg, err := r.GetResult("abc")
@@ -123,7 +148,7 @@ func GetResult(t *testing.T) {
 	mockConn.Mock.AssertExpectations(t)
 	if assert.NotNil(msg) {
 		assert.False(msg.Done)
-		assert.Equal([]*message{}, msg.Responses)
+		assert.Equal([]*Message{}, msg.Responses)
 	}
 	assert.Nil(err)
 }
@@ -133,7 +158,8 @@ func GetResult(t *testing.T) {
 func GetResultError(t *testing.T) {
 	assert := assert.New(t)
 	mockConn := new(mockConn)
-	r := &redisPersister{mu: sync.RWMutex{}, conn: mockConn}
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
 	mockConn.On("Do", "GET", "abc").Return(nil, fmt.Errorf("error"))
 
 	msg, err := r.GetResult("abc")
@@ -148,9 +174,10 @@ func GetResultError(t *testing.T) {
 func GetMessages(t *testing.T) {
 	assert := assert.New(t)
 	mockConn := new(mockConn)
-	r := &redisPersister{mu: sync.RWMutex{}, conn: mockConn}
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
 	var res interface{}
-	msgJSON, _ := json.Marshal(&message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412006603})
+	msgJSON, _ := json.Marshal(&Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412006603})
 	res = [][]byte{msgJSON}
 	mockConn.On("Do", "ZRANGEBYSCORE", "foo", "(1412006600", "+inf").Return(res, nil)
 
@@ -172,7 +199,8 @@ func GetMessages(t *testing.T) {
 func GetMessagesError(t *testing.T) {
 	assert := assert.New(t)
 	mockConn := new(mockConn)
-	r := &redisPersister{mu: sync.RWMutex{}, conn: mockConn}
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
 	mockConn.On("Do", "ZRANGEBYSCORE", "(1412006600", "+inf").Return(nil, fmt.Errorf("error"))
 
 	messages, err := r.GetMessages("foo", 1412006600)
@@ -181,3 +209,354 @@ func GetMessagesError(t *testing.T) {
 	assert.Nil(messages)
 	assert.NotNil(err)
 }
+
+// Ensures that NewRedisPersister uses the default address when none is
+// configured.
+func TestNewRedisPersisterDefaults(t *testing.T) {
+	r := NewRedisPersister(&RedisConfig{Password: "secret"}).(*redisPersister)
+
+	assert.Equal(t, defaultRedisAddr, r.config.Addr)
+	assert.Equal(t, "secret", r.config.Password)
+}
+
+// Ensures that Prepare verifies the pool with a PING.
+func TestPrepare(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Do", "PING", []interface{}(nil)).Return("PONG", nil)
+	mockConn.On("Close").Return(nil)
+
+	err := r.Prepare()
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that testOnBorrow only pings connections which have been idle for a
+// while.
+func TestTestOnBorrow(t *testing.T) {
+	mockConn := new(mockConn)
+	mockConn.On("Do", "PING", []interface{}(nil)).Return(nil, fmt.Errorf("error")).Once()
+
+	assert.Nil(t, testOnBorrow(mockConn, time.Now()))
+	assert.NotNil(t, testOnBorrow(mockConn, time.Now().Add(-2*redisHealthCheckInterval)))
+	mockConn.Mock.AssertExpectations(t)
+}
+
+// Ensures that SaveResult sets an expiry when the retention policy has a
+// ResultTTL.
+func TestSaveResultTTL(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	r.SetRetention(RetentionPolicy{ResultTTL: 90 * time.Second})
+	mockConn.On("Close").Return(nil)
+	result := &Result{Done: false, Responses: []*Message{}}
+	resultJSON, _ := json.Marshal(result)
+	args := []interface{}{"vessel:result:abc", resultJSON, "EX", int64(90)}
+	mockConn.On("Do", "SET", args).Return(nil, nil)
+
+	err := r.SaveResult("abc", result)
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that SaveMessage tracks the channel and trims it to the retention
+// policy's MaxMessages.
+func TestSaveMessageMaxMessages(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	r.SetRetention(RetentionPolicy{MaxMessages: 100})
+	mockConn.On("Close").Return(nil)
+	message := &Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412006603}
+	messageJSON, _ := json.Marshal(message)
+	mockConn.On("Do", "ZADD", []interface{}{"vessel:history:foo", int64(1412006603), messageJSON}).Return(nil, nil)
+	mockConn.On("Do", "SADD", []interface{}{redisChannelsKey, "foo"}).Return(nil, nil)
+	mockConn.On("Do", "SADD", []interface{}{"vessel:channels:foo", "foo"}).Return(nil, nil)
+	mockConn.On("Do", "ZREMRANGEBYRANK", []interface{}{"vessel:history:foo", 0, -101}).Return(nil, nil)
+
+	err := r.SaveMessage("foo", message)
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that Trim trims each tracked channel and forgets channels which no
+// longer exist.
+func TestTrim(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	r.SetRetention(RetentionPolicy{MaxMessages: 10})
+	mockConn.On("Close").Return(nil)
+	mockConn.On("Do", "SMEMBERS", []interface{}{redisChannelsKey}).
+		Return([]interface{}{[]byte("foo"), []byte("bar")}, nil)
+	mockConn.On("Do", "ZREMRANGEBYRANK", []interface{}{"vessel:history:foo", 0, -11}).Return(int64(0), nil)
+	mockConn.On("Do", "ZREMRANGEBYRANK", []interface{}{"vessel:history:bar", 0, -11}).Return(int64(0), nil)
+	mockConn.On("Do", "EXISTS", []interface{}{"vessel:history:foo"}).Return(int64(1), nil)
+	mockConn.On("Do", "EXISTS", []interface{}{"vessel:history:bar"}).Return(int64(0), nil)
+	mockConn.On("Do", "SREM", []interface{}{"vessel:channels:bar", "bar"}).Return(int64(1), nil)
+	mockConn.On("Do", "SREM", []interface{}{redisChannelsKey, "bar"}).Return(int64(1), nil)
+
+	err := r.Trim()
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that QueueMessage adds the message to the user's queue, bounds it by
+// the retention policy's MaxMessages and expires it after its MessageTTL.
+func TestQueueMessage(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	r.SetRetention(RetentionPolicy{MessageTTL: time.Minute, MaxMessages: 10})
+	mockConn.On("Close").Return(nil)
+	message := &Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412006603}
+	messageJSON, _ := json.Marshal(message)
+	mockConn.On("Do", "HSET", []interface{}{"vessel:queued:user", "abc", messageJSON}).Return(int64(1), nil)
+	mockConn.On("Do", "ZADD", []interface{}{"vessel:queue:user", int64(1412006603), "abc"}).Return(int64(1), nil)
+	mockConn.On("Do", "ZRANGE", []interface{}{"vessel:queue:user", 0, -11}).
+		Return([]interface{}{[]byte("old")}, nil)
+	mockConn.On("Do", "ZREM", []interface{}{"vessel:queue:user", "old"}).Return(int64(1), nil)
+	mockConn.On("Do", "HDEL", []interface{}{"vessel:queued:user", "old"}).Return(int64(1), nil)
+	mockConn.On("Do", "EXPIRE", []interface{}{"vessel:queued:user", int64(60)}).Return(int64(1), nil)
+	mockConn.On("Do", "EXPIRE", []interface{}{"vessel:queue:user", int64(60)}).Return(int64(1), nil)
+
+	err := r.QueueMessage("user", message)
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that TakeQueued reads and deletes the user's queue in a transaction
+// and returns the messages which haven't outlived the retention policy in
+// order.
+func TestTakeQueued(t *testing.T) {
+	assert := assert.New(t)
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	r.SetRetention(RetentionPolicy{MessageTTL: time.Minute})
+	mockConn.On("Close").Return(nil)
+	now := time.Now().Unix()
+	expired, _ := json.Marshal(&Message{ID: "x", Channel: "foo", Body: "x", Timestamp: now - 120})
+	first, _ := json.Marshal(&Message{ID: "a", Channel: "foo", Body: "a", Timestamp: now - 10})
+	second, _ := json.Marshal(&Message{ID: "b", Channel: "foo", Body: "b", Timestamp: now})
+	mockConn.On("Send", "MULTI", []interface{}(nil)).Return(nil)
+	mockConn.On("Send", "HVALS", []interface{}{"vessel:queued:user"}).Return(nil)
+	mockConn.On("Send", "DEL", []interface{}{"vessel:queued:user", "vessel:queue:user"}).Return(nil)
+	mockConn.On("Do", "EXEC", []interface{}(nil)).
+		Return([]interface{}{[]interface{}{expired, second, first}, int64(2)}, nil)
+
+	messages, err := r.TakeQueued("user")
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(err)
+	if assert.Equal(2, len(messages)) {
+		assert.Equal("a", messages[0].ID)
+		assert.Equal("b", messages[1].ID)
+	}
+}
+
+// Ensures that DeleteQueued removes only the message with the ID from the
+// user's queue without reading it.
+func TestDeleteQueued(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	mockConn.On("Do", "ZREM", []interface{}{"vessel:queue:user", "b"}).Return(int64(1), nil)
+	mockConn.On("Do", "HDEL", []interface{}{"vessel:queued:user", "b"}).Return(int64(1), nil)
+
+	err := r.DeleteQueued("user", "b")
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that AddMember stores the member in the channel's presence hash.
+func TestAddMember(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	member := &Member{ID: "s1", Principal: "user", Joined: 1412006603}
+	memberJSON, _ := json.Marshal(member)
+	mockConn.On("Do", "HSET", []interface{}{"vessel:presence:foo", "s1", memberJSON}).Return(int64(1), nil)
+
+	err := r.AddMember("foo", member)
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that GetMembers reads the members from the channel's presence hash.
+func TestGetMembers(t *testing.T) {
+	assert := assert.New(t)
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	memberJSON, _ := json.Marshal(&Member{ID: "s1", Joined: 1412006603})
+	mockConn.On("Do", "HVALS", []interface{}{"vessel:presence:foo"}).Return([]interface{}{memberJSON}, nil)
+
+	members, err := r.GetMembers("foo")
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(err)
+	if assert.Len(members, 1) {
+		assert.Equal("s1", members[0].ID)
+	}
+}
+
+// Ensures that GetMembers leaves out and removes the members of nodes which
+// have stopped sending heartbeats.
+func TestGetMembersStaleNode(t *testing.T) {
+	assert := assert.New(t)
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	live, _ := json.Marshal(&Member{ID: "s1", Node: "n1", Joined: 1412006603})
+	stale, _ := json.Marshal(&Member{ID: "s2", Node: "n2", Joined: 1412006603})
+	other, _ := json.Marshal(&Member{ID: "s3", Node: "n1", Joined: 1412006603})
+	mockConn.On("Do", "HVALS", []interface{}{"vessel:presence:foo"}).
+		Return([]interface{}{live, stale, other}, nil)
+	mockConn.On("Do", "EXISTS", []interface{}{"vessel:node:n1"}).Return(int64(1), nil).Once()
+	mockConn.On("Do", "EXISTS", []interface{}{"vessel:node:n2"}).Return(int64(0), nil).Once()
+	mockConn.On("Do", "HDEL", []interface{}{"vessel:presence:foo", "s2"}).Return(int64(1), nil)
+
+	members, err := r.GetMembers("foo")
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(err)
+	if assert.Len(members, 2) {
+		assert.Equal("s1", members[0].ID)
+		assert.Equal("s3", members[1].ID)
+	}
+}
+
+// Ensures that Heartbeat sets the node's key to expire after the duration.
+func TestHeartbeat(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	mockConn.On("Do", "SET", []interface{}{"vessel:node:n1", 1, "EX", int64(30)}).Return("OK", nil)
+
+	err := r.Heartbeat("n1", 30*time.Second)
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that GetMessages reads the history of every channel matching a
+// pattern and returns it in timestamp order.
+func TestGetMessagesPattern(t *testing.T) {
+	assert := assert.New(t)
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	eu, _ := json.Marshal(&Message{ID: "a", Channel: "orders.eu", Body: "a", Timestamp: 20})
+	us, _ := json.Marshal(&Message{ID: "b", Channel: "orders.us", Body: "b", Timestamp: 10})
+	mockConn.On("Do", "SMEMBERS", []interface{}{"vessel:channels:orders"}).
+		Return([]interface{}{[]byte("orders.eu"), []byte("orders.us"), []byte("orders.eu.new")}, nil)
+	mockConn.On("Send", "MULTI", []interface{}(nil)).Return(nil)
+	mockConn.On("Send", "ZRANGEBYSCORE", []interface{}{"vessel:history:orders.eu", "(0", "+inf"}).Return(nil)
+	mockConn.On("Send", "ZRANGEBYSCORE", []interface{}{"vessel:history:orders.us", "(0", "+inf"}).Return(nil)
+	mockConn.On("Do", "EXEC", []interface{}(nil)).
+		Return([]interface{}{[]interface{}{eu}, []interface{}{us}}, nil)
+
+	messages, err := r.GetMessages("orders.*", 0)
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(err)
+	if assert.Len(messages, 2) {
+		assert.Equal("b", messages[0].ID)
+		assert.Equal("a", messages[1].ID)
+	}
+}
+
+// Ensures that GetMessages skips messages older than the retention policy's
+// MessageTTL.
+func TestGetMessagesMessageTTL(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	r.SetRetention(RetentionPolicy{MessageTTL: time.Minute})
+	mockConn.On("Close").Return(nil)
+	before := time.Now().Add(-time.Minute).Unix() - 1
+	mockConn.On("Do", "ZRANGEBYSCORE", mock.MatchedBy(func(args []interface{}) bool {
+		min, _ := strconv.ParseInt(strings.TrimPrefix(args[1].(string), "("), 10, 64)
+		return args[0] == "vessel:history:foo" && min >= before && min <= time.Now().Add(-time.Minute).Unix()-1
+	})).Return([]interface{}{}, nil)
+
+	_, err := r.GetMessages("foo", 0)
+
+	mockConn.Mock.AssertExpectations(t)
+	assert.Nil(t, err)
+}
+
+// Ensures that GetMessages scans every channel for patterns starting with a
+// wildcard and doesn't read history when none match.
+func TestGetMessagesPatternWildcardPrefix(t *testing.T) {
+	assert := assert.New(t)
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+	mockConn.On("Close").Return(nil)
+	mockConn.On("Do", "SMEMBERS", []interface{}{redisChannelsKey}).
+		Return([]interface{}{[]byte("orders.us"), []byte("users.us")}, nil)
+
+	messages, err := r.GetMessages("*.eu", 0)
+
+	mockConn.Mock.AssertExpectations(t)
+	mockConn.Mock.AssertNotCalled(t, "Send", mock.Anything, mock.Anything)
+	assert.Nil(err)
+	assert.Len(messages, 0)
+}
+
+// Ensures that Trim does nothing without a message retention policy.
+func TestTrimNoPolicy(t *testing.T) {
+	mockConn := new(mockConn)
+	r := &redisPersister{pool: &mockPool{conn: mockConn}}
+
+	assert.Nil(t, r.Trim())
+	mockConn.Mock.AssertNotCalled(t, "Do")
+}
+
+// Ensures that Close closes the connection pool.
+func TestClose(t *testing.T) {
+	pool := &mockPool{}
+	r := &redisPersister{pool: pool}
+
+	assert.Nil(t, r.Close())
+	assert.True(t, pool.closed)
+}
+
+// Ensures that the Redis Backplane publishes on its channel.
+func TestRedisBackplanePublish(t *testing.T) {
+	mockConn := new(mockConn)
+	mockConn.On("Do", "PUBLISH", []interface{}{"vessel:backplane", []byte("data")}).Return(int64(1), nil)
+	mockConn.On("Close").Return(nil)
+	r := NewRedisBackplane(nil, "").(*redisBackplane)
+	r.pool = &mockPool{conn: mockConn}
+
+	assert.Nil(t, r.Publish([]byte("data")))
+	mockConn.AssertExpectations(t)
+}
+
+// Ensures that the Redis Backplane delivers messages received on its
+// subscription until it's closed.
+func TestRedisBackplaneSubscribe(t *testing.T) {
+	mockConn := new(mockConn)
+	mockConn.On("Send", "SUBSCRIBE", []interface{}{"cluster"}).Return(nil)
+	mockConn.On("Flush").Return(nil)
+	mockConn.On("Receive").Return([]interface{}{[]byte("message"), []byte("cluster"), []byte("data")}, nil).Once()
+	mockConn.On("Receive").Return(nil, fmt.Errorf("Connection closed"))
+	mockConn.On("Close").Return(nil)
+	r := NewRedisBackplane(nil, "cluster").(*redisBackplane)
+	r.dial = func() (redis.Conn, error) { return mockConn, nil }
+	received := make(chan []byte, 1)
+
+	assert.Nil(t, r.Subscribe(func(data []byte) { received <- data }))
+
+	select {
+	case data := <-received:
+		assert.Equal(t, []byte("data"), data)
+	case <-time.After(time.Second):
+		t.Fatal("Expected message")
+	}
+	assert.Nil(t, r.Close())
+}
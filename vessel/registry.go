@@ -0,0 +1,221 @@
+package vessel
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionInfo describes a session connected to the Vessel.
+type SessionInfo struct {
+	// ID identifies the session.
+	ID string
+
+	// Principal is the client the session authenticated as, or nil if it
+	// hasn't authenticated.
+	Principal *Principal
+
+	// Metadata contains information about the connection, such as its
+	// transport and remote address.
+	Metadata map[string]string
+
+	// Subscriptions are the channels the session is subscribed to.
+	Subscriptions []string
+
+	// Connected is when the session connected.
+	Connected time.Time
+}
+
+// sessionRegistry holds the sessions connected to the Vessel, indexed by ID
+// and by the ID of the Principal they authenticated as. It's safe for
+// concurrent use.
+type sessionRegistry struct {
+	byID        map[string]*session
+	byPrincipal map[string]map[*session]struct{}
+	mu          sync.RWMutex
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		byID:        map[string]*session{},
+		byPrincipal: map[string]map[*session]struct{}{},
+	}
+}
+
+// add registers the session, returning false without registering it if a
+// session with the same ID is already registered. SockJS clients choose their
+// own session IDs, so replacing the registered session would let a client
+// take over another's messages.
+func (r *sessionRegistry) add(s *session) bool {
+	id := s.ID()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[id]; ok {
+		return false
+	}
+	s.id = id
+	r.byID[id] = s
+	r.index(s)
+	return true
+}
+
+// remove unregisters the session if it's registered.
+func (r *sessionRegistry) remove(s *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byID[s.id] != s {
+		return
+	}
+	delete(r.byID, s.id)
+	r.unindex(s)
+}
+
+// authenticate records the Principal the session authenticated as, indexing
+// it under the Principal if it's registered.
+func (r *sessionRegistry) authenticate(s *session, principal *Principal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	registered := s.id != "" && r.byID[s.id] == s
+	if registered {
+		r.unindex(s)
+	}
+	s.setPrincipal(principal)
+	if registered {
+		r.index(s)
+	}
+}
+
+// get returns the session with the ID or nil if there isn't one.
+func (r *sessionRegistry) get(id string) *session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byID[id]
+}
+
+// forPrincipal returns the sessions authenticated as the Principal with the
+// ID.
+func (r *sessionRegistry) forPrincipal(id string) []*session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sessions := make([]*session, 0, len(r.byPrincipal[id]))
+	for s := range r.byPrincipal[id] {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// all returns a snapshot of the registered sessions which may be iterated
+// while sessions come and go.
+func (r *sessionRegistry) all() []*session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sessions := make([]*session, 0, len(r.byID))
+	for _, s := range r.byID {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// len returns the number of registered sessions.
+func (r *sessionRegistry) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byID)
+}
+
+// index adds the session to the index of its Principal. The caller must hold
+// the lock.
+func (r *sessionRegistry) index(s *session) {
+	principal := s.getPrincipal()
+	if principal == nil {
+		return
+	}
+	sessions, ok := r.byPrincipal[principal.ID]
+	if !ok {
+		sessions = map[*session]struct{}{}
+		r.byPrincipal[principal.ID] = sessions
+	}
+	sessions[s] = struct{}{}
+}
+
+// unindex removes the session from the index of its Principal. The caller
+// must hold the lock.
+func (r *sessionRegistry) unindex(s *session) {
+	principal := s.getPrincipal()
+	if principal == nil {
+		return
+	}
+	sessions := r.byPrincipal[principal.ID]
+	delete(sessions, s)
+	if len(sessions) == 0 {
+		delete(r.byPrincipal, principal.ID)
+	}
+}
+
+// info returns a snapshot describing the session.
+func (s *session) info() *SessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subscriptions := make([]string, 0, len(s.subscriptions))
+	for channel := range s.subscriptions {
+		subscriptions = append(subscriptions, channel)
+	}
+	sort.Strings(subscriptions)
+	return &SessionInfo{
+		ID:            s.id,
+		Principal:     s.principal,
+		Metadata:      copyMetadata(s.metadata),
+		Subscriptions: subscriptions,
+		Connected:     s.connected,
+	}
+}
+
+// Sessions returns the sessions connected to this node.
+func (s *sockjsVessel) Sessions() []*SessionInfo {
+	return sessionInfos(s.sessions.all())
+}
+
+// Session returns the session connected to this node with the ID.
+func (s *sockjsVessel) Session(id string) (*SessionInfo, bool) {
+	session := s.sessions.get(id)
+	if session == nil {
+		return nil, false
+	}
+	return session.info(), true
+}
+
+// SessionsFor returns the sessions connected to this node which authenticated
+// as the Principal with the ID.
+func (s *sockjsVessel) SessionsFor(principalID string) []*SessionInfo {
+	return sessionInfos(s.sessions.forPrincipal(principalID))
+}
+
+func sessionInfos(sessions []*session) []*SessionInfo {
+	infos := make([]*SessionInfo, len(sessions))
+	for i, session := range sessions {
+		infos[i] = session.info()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Connected.Before(infos[j].Connected)
+	})
+	return infos
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	c := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
+
+// requestMetadata describes the client which made the request over the
+// transport.
+func requestMetadata(r *http.Request, transport string) map[string]string {
+	return map[string]string{
+		"transport":  transport,
+		"remoteAddr": r.RemoteAddr,
+		"userAgent":  r.UserAgent(),
+	}
+}
@@ -0,0 +1,129 @@
+package vessel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newRegistrySession(id string) *session {
+	mockSession := new(mockSession)
+	mockSession.On("ID").Return(id)
+	mockSession.On("Send", mock.Anything).Return(nil)
+	return newSession(context.Background(), mockSession)
+}
+
+// Ensures that sessionRegistry looks up sessions by ID, rejects sessions whose
+// ID is already registered, and only removes the session registered under an
+// ID.
+func TestSessionRegistry(t *testing.T) {
+	assert := assert.New(t)
+	registry := newSessionRegistry()
+	first := newRegistrySession("abc")
+	second := newRegistrySession("abc")
+
+	assert.True(registry.add(first))
+	assert.Equal(first, registry.get("abc"))
+	assert.False(registry.add(second))
+	assert.Equal(first, registry.get("abc"))
+	registry.remove(second)
+	assert.Equal(first, registry.get("abc"))
+	registry.remove(first)
+	assert.Nil(registry.get("abc"))
+	assert.Equal(0, registry.len())
+}
+
+// Ensures that sessionRegistry indexes sessions by the Principal they
+// authenticate as.
+func TestSessionRegistryPrincipal(t *testing.T) {
+	assert := assert.New(t)
+	registry := newSessionRegistry()
+	session1 := newRegistrySession("abc")
+	session2 := newRegistrySession("def")
+	session2.setPrincipal(&Principal{ID: "alice"})
+
+	registry.add(session1)
+	registry.add(session2)
+	assert.Len(registry.forPrincipal("alice"), 1)
+
+	registry.authenticate(session1, &Principal{ID: "alice"})
+	assert.Len(registry.forPrincipal("alice"), 2)
+	registry.authenticate(session1, &Principal{ID: "bob"})
+	assert.Equal([]*session{session1}, registry.forPrincipal("bob"))
+
+	registry.remove(session2)
+	assert.Empty(registry.forPrincipal("alice"))
+}
+
+// Ensures that sessions can come and go while broadcasting.
+func TestSessionRegistryConcurrent(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			session := newRegistrySession(fmt.Sprintf("session%d", i))
+			session.subscribe("foo")
+			v.sessions.add(session)
+			v.sessions.authenticate(session, &Principal{ID: "alice"})
+			v.sessions.remove(session)
+		}(i)
+		go func() {
+			defer wg.Done()
+			v.Broadcast("foo", "bar")
+			v.SessionsFor("alice")
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, 0, v.sessions.len())
+}
+
+// Ensures that the Vessel describes its connected sessions.
+func TestSessions(t *testing.T) {
+	assert := assert.New(t)
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	session := newRegistrySession("abc")
+	session.metadata = map[string]string{"transport": "websocket"}
+	session.subscribe("foo")
+	session.subscribe("bar")
+	session.setPrincipal(&Principal{ID: "alice"})
+	v.sessions.add(session)
+
+	info, ok := v.Session("abc")
+	if assert.True(ok) {
+		assert.Equal("abc", info.ID)
+		assert.Equal("alice", info.Principal.ID)
+		assert.Equal([]string{"bar", "foo"}, info.Subscriptions)
+		assert.Equal("websocket", info.Metadata["transport"])
+	}
+	_, ok = v.Session("def")
+	assert.False(ok)
+	assert.Len(v.Sessions(), 1)
+	assert.Len(v.SessionsFor("alice"), 1)
+	assert.Empty(v.SessionsFor("bob"))
+}
+
+// Ensures that handlers can be added while messages are received.
+func TestAddHandlerConcurrent(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		channel := fmt.Sprintf("channel%d", i)
+		go func() {
+			defer wg.Done()
+			v.AddHandler(channel, func(ctx context.Context, req *Request, results chan<- string) {})
+		}()
+		go func() {
+			defer wg.Done()
+			v.RecvRequest(context.Background(), &Request{ID: "abc", Channel: channel})
+		}()
+	}
+	wg.Wait()
+}
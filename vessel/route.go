@@ -0,0 +1,97 @@
+package vessel
+
+import "time"
+
+const (
+	// envelopeResponse is the kind of envelope carrying a response addressed
+	// to a session connected to another node.
+	envelopeResponse = "response"
+
+	// envelopeResult is the kind of envelope announcing that a result has
+	// been saved, so polls waiting on it on other nodes check it again.
+	envelopeResult = "result"
+
+	// sessionChannel is the reserved channel clients send on to learn their
+	// session ID. The reply has the ID of the request and the session ID as
+	// its body.
+	sessionChannel = "_session"
+
+	// sessionParam is the query string parameter HTTP clients pass a session
+	// ID in to have the responses to a message sent to that session as well.
+	sessionParam = "session"
+)
+
+// sessionRouter delivers responses to sessions and announces saved results,
+// across nodes if there's a Backplane. It's implemented by sockjsVessel.
+type sessionRouter interface {
+	route(session, owner string, msg *Message)
+	resultSaved(id string)
+}
+
+// route sends the message to the session, relaying it through the Backplane
+// if the session isn't connected to this node. If owner is set, the message
+// is only delivered if the session is authenticated as the Principal with
+// that ID.
+func (s *sockjsVessel) route(sessionID, owner string, msg *Message) {
+	if s.sendLocal(sessionID, owner, msg) {
+		return
+	}
+	if s.backplane != nil {
+		s.publish(&envelope{
+			Kind:    envelopeResponse,
+			Session: sessionID,
+			Owner:   owner,
+			Message: msg,
+		})
+	}
+}
+
+// sendLocal sends the message to the session if it's connected to this node
+// and belongs to the owner, returning false if it isn't connected.
+func (s *sockjsVessel) sendLocal(sessionID, owner string, msg *Message) bool {
+	session := s.sessions.get(sessionID)
+	if session == nil {
+		return false
+	}
+	if owner != "" {
+		if principal := session.getPrincipal(); principal == nil || principal.ID != owner {
+			s.log.infof("Dropped message %s for session %s owned by another principal", msg.ID, sessionID)
+			return true
+		}
+	}
+
+	send, err := s.marshaler.Marshal(msg)
+	if err != nil {
+		s.log.errorf("Failed to marshal message %s: %s", msg.ID, err)
+		return true
+	}
+	sendStr := string(send)
+	s.log.debugf("Send %s", sendStr)
+	session.Send(sendStr)
+	return true
+}
+
+// resultSaved wakes polls waiting on the result on every node.
+func (s *sockjsVessel) resultSaved(id string) {
+	s.httpHandler.notifier.notify(resultKey(id))
+	if s.backplane != nil {
+		s.publish(&envelope{Kind: envelopeResult, ID: id})
+	}
+}
+
+// replySessionID answers a request on the _session channel with the
+// session's ID.
+func (s *sockjsVessel) replySessionID(session *session, request *Message) {
+	msg := &Message{
+		ID:        request.ID,
+		Channel:   sessionChannel,
+		Body:      session.ID(),
+		Timestamp: time.Now().Unix(),
+	}
+	send, err := s.marshaler.Marshal(msg)
+	if err != nil {
+		s.log.errorf("Failed to marshal message %s: %s", msg.ID, err)
+		return
+	}
+	session.Send(string(send))
+}
@@ -0,0 +1,153 @@
+package vessel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// addMockSession connects a session with the ID and Principal to the Vessel.
+func addMockSession(v *sockjsVessel, id string, principal *Principal) *mockSession {
+	mockSession := new(mockSession)
+	mockSession.On("ID").Return(id)
+	mockSession.On("Send", mock.Anything).Return(nil)
+	session := newSession(context.Background(), mockSession)
+	session.setPrincipal(principal)
+	v.sessions.add(session)
+	return mockSession
+}
+
+// Ensures that route sends to a session connected to the same node.
+func TestRouteLocal(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	session := addMockSession(v, "s1", nil)
+	addMockSession(v, "s2", nil)
+
+	v.route("s1", "", &Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412003438})
+
+	session.AssertCalled(t, "Send", `{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`)
+	session.AssertNumberOfCalls(t, "Send", 1)
+}
+
+// Ensures that route relays messages to sessions on other nodes through the
+// Backplane.
+func TestRouteBackplane(t *testing.T) {
+	bus := NewMemoryBus()
+	node1, _ := newNode(t, bus, "foo")
+	node2, _ := newNode(t, bus, "foo")
+	session := addMockSession(node2, "s1", nil)
+
+	node1.route("s1", "", &Message{ID: "abc", Channel: "foo", Body: "bar", Timestamp: 1412003438})
+
+	session.AssertCalled(t, "Send", `{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`)
+}
+
+// Ensures that route doesn't deliver to sessions authenticated as a
+// different Principal than the owner.
+func TestRouteOwner(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	alice := addMockSession(v, "s1", &Principal{ID: "alice"})
+	bob := addMockSession(v, "s2", &Principal{ID: "bob"})
+
+	v.route("s1", "alice", &Message{ID: "abc", Channel: "foo"})
+	v.route("s2", "alice", &Message{ID: "abc", Channel: "foo"})
+
+	alice.AssertNumberOfCalls(t, "Send", 1)
+	bob.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+// Ensures that resultSaved wakes polls waiting on the result on other nodes.
+func TestResultSavedBackplane(t *testing.T) {
+	bus := NewMemoryBus()
+	node1, _ := newNode(t, bus, "foo")
+	node2, _ := newNode(t, bus, "foo")
+	notified, done := node2.httpHandler.notifier.wait(resultKey("abc"))
+	defer done()
+
+	node1.resultSaved("abc")
+
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Fatal("Expected notification")
+	}
+}
+
+// Ensures that control replies to _session requests with the session's ID.
+func TestControlSession(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	mockSession := new(mockSession)
+	mockSession.On("ID").Return("s1")
+	mockSession.On("Send", mock.MatchedBy(func(msg string) bool {
+		return strings.HasPrefix(msg, `{"id":"abc","channel":"_session","body":"s1",`)
+	})).Return(nil)
+	session := newSession(context.Background(), mockSession)
+
+	assert.True(t, v.control(session, &Message{ID: "abc", Channel: sessionChannel}))
+	mockSession.AssertExpectations(t)
+}
+
+// Ensures that responses to messages sent over HTTP with a session parameter
+// are routed to that session on another node when it belongs to the sender.
+func TestSendSessionBackplane(t *testing.T) {
+	bus := NewMemoryBus()
+	persister := NewMemoryPersister(0)
+	nodes := make([]*sockjsVessel, 2)
+	for i := range nodes {
+		nodes[i] = NewSockJSVesselWithOptions("/vessel", &Options{
+			Persister:     persister,
+			Backplane:     bus.Backplane(),
+			Authenticator: AuthenticatorFunc(mockAuthenticator),
+		}).(*sockjsVessel)
+		nodes[i].Prepare()
+	}
+	nodes[0].AddHandler("foo", func(ctx context.Context, req *Request, results chan<- string) {
+		results <- "hello " + req.Body
+	})
+	received := make(chan string, 1)
+	mockSession := new(mockSession)
+	mockSession.On("ID").Return("s1")
+	mockSession.On("Send", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		received <- args.String(0)
+	})
+	session := newSession(context.Background(), mockSession)
+	session.setPrincipal(&Principal{ID: "user"})
+	nodes[1].sessions.add(session)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/vessel?session=s1&token=secret",
+		strings.NewReader(`{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`))
+	nodes[0].Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	select {
+	case msg := <-received:
+		assert.Contains(t, msg, `"body":"hello bar"`)
+	case <-time.After(time.Second):
+		t.Fatal("Expected response on session")
+	}
+}
+
+// Ensures that unauthenticated HTTP requests can't send responses to a
+// session.
+func TestSendSessionUnauthenticated(t *testing.T) {
+	v := NewSockJSVesselWithOptions("/vessel", &Options{Persister: NewMemoryPersister(0)}).(*sockjsVessel)
+	v.AddHandler("foo", func(ctx context.Context, req *Request, results chan<- string) {
+		results <- "hello"
+	})
+	session := addMockSession(v, "s1", nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/vessel?session=s1",
+		strings.NewReader(`{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`))
+	v.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	session.AssertNotCalled(t, "Send", mock.Anything)
+}
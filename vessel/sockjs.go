@@ -1,9 +1,13 @@
 package vessel
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/igm/sockjs-go/sockjs"
@@ -11,52 +15,482 @@ import (
 
 type sockjsVessel struct {
 	uri              string
-	sessions         []sockjs.Session
-	channels         map[string]Channel
-	marshaler        marshaler
-	idGenerator      idGenerator
+	node             string
+	sessions         *sessionRegistry
+	handlers         map[string]Handler
+	patterns         []string
+	marshaler        Marshaler
+	idGenerator      IDGenerator
 	messageGenerator messageGenerator
 	httpHandler      *httpHandler
+	sockjsHandler    http.Handler
+	websocketHandler http.Handler
 	persister        Persister
+	backplane        Backplane
+	presence         *PresenceConfig
+	recent           *recentIDs
+	retention        *RetentionPolicy
+	trimInterval     time.Duration
+	handlerTimeout   time.Duration
+	tls              *TLSConfig
+	authenticator    Authenticator
+	sessionAuth      *sessionAuthenticator
+	authorizer       Authorizer
+	cors             *CORSConfig
+	log              *logger
+	servers          []*http.Server
+	ctx              context.Context
+	cancel           context.CancelFunc
+	closing          bool
+	prepared         bool
+	prepareMu        sync.Mutex
+	wg               sync.WaitGroup
+	stop             chan struct{}
+	mu               sync.RWMutex
+}
+
+// session wraps a sockjs.Session and tracks the channels it is subscribed to
+// and when it subscribed to them. Its Context is cancelled once the client
+// has disconnected.
+type session struct {
+	sockjs.Session
+	id            string
+	memberID      string
+	subscriptions map[string]time.Time
+	principal     *Principal
+	notifyDone    bool
+	metadata      map[string]string
+	connected     time.Time
+	ctx           context.Context
+	cancel        context.CancelFunc
+	mu            sync.RWMutex
+}
+
+func newSession(parent context.Context, sockjsSession sockjs.Session) *session {
+	ctx, cancel := context.WithCancel(parent)
+	return &session{
+		Session:       sockjsSession,
+		memberID:      newUUID(),
+		subscriptions: map[string]time.Time{},
+		metadata:      map[string]string{},
+		connected:     time.Now(),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// subscribe adds the channel to the session's subscriptions, returning when
+// it subscribed and false if it was already subscribed.
+func (s *session) subscribe(channel string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if joined, ok := s.subscriptions[channel]; ok {
+		return joined, false
+	}
+	joined := time.Now()
+	s.subscriptions[channel] = joined
+	return joined, true
+}
+
+// unsubscribe removes the channel from the session's subscriptions, returning
+// when it subscribed and false if it wasn't subscribed.
+func (s *session) unsubscribe(channel string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	joined, ok := s.subscriptions[channel]
+	delete(s.subscriptions, channel)
+	return joined, ok
+}
+
+// unsubscribeAll removes all of the session's subscriptions, returning when
+// it subscribed to each channel.
+func (s *session) unsubscribeAll() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscriptions := s.subscriptions
+	s.subscriptions = map[string]time.Time{}
+	return subscriptions
+}
+
+// setNotifyDone has the session be told when the handlers of its messages
+// complete.
+func (s *session) setNotifyDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifyDone = true
+}
+
+// notifiesDone indicates if the session is told when the handlers of its
+// messages complete.
+func (s *session) notifiesDone() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.notifyDone
+}
+
+// setPrincipal records the Principal the session authenticated as.
+func (s *session) setPrincipal(principal *Principal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.principal = principal
+}
+
+// getPrincipal returns the Principal the session authenticated as, or nil if
+// it hasn't authenticated.
+func (s *session) getPrincipal() *Principal {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.principal
+}
+
+// subscribed indicates if the session is subscribed to the channel or to a
+// pattern matching it.
+func (s *session) subscribed(channel string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.subscriptions[channel]; ok {
+		return true
+	}
+	for subscription := range s.subscriptions {
+		if MatchChannel(subscription, channel) {
+			return true
+		}
+	}
+	return false
 }
 
 // NewSockJSVessel returns a new Vessel which relies on SockJS as the underlying transport.
 func NewSockJSVessel(uri string) Vessel {
+	return NewSockJSVesselWithOptions(uri, nil)
+}
+
+// NewSockJSVesselWithOptions returns a new Vessel which relies on SockJS as the
+// underlying transport and is configured with the given Options. Nil Options
+// use the defaults.
+func NewSockJSVesselWithOptions(uri string, options *Options) Vessel {
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	opts.setDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
 	vessel := &sockjsVessel{
 		uri:              uri,
-		channels:         map[string]Channel{},
-		sessions:         []sockjs.Session{},
-		marshaler:        &jsonMarshaler{},
-		idGenerator:      newUUID,
+		node:             newUUID(),
+		handlers:         map[string]Handler{},
+		sessions:         newSessionRegistry(),
+		marshaler:        opts.Marshaler,
+		idGenerator:      opts.IDGenerator,
 		messageGenerator: newMessage,
-		persister:        NewPersister(),
+		persister:        opts.Persister,
+		backplane:        opts.Backplane,
+		presence:         opts.Presence,
+		recent:           newRecentIDs(recentIDsSize),
+		retention:        opts.Retention,
+		trimInterval:     opts.TrimInterval,
+		handlerTimeout:   opts.HandlerTimeout,
+		tls:              opts.TLS,
+		authenticator:    opts.Authenticator,
+		authorizer:       opts.Authorizer,
+		cors:             opts.CORS,
+		log:              newLogger(opts.LogLevel, opts.Logger),
+		ctx:              ctx,
+		cancel:           cancel,
+		stop:             make(chan struct{}),
+	}
+	if opts.Retention != nil {
+		if retention, ok := vessel.persister.(RetentionPersister); ok {
+			retention.SetRetention(*opts.Retention)
+		} else {
+			vessel.log.errorf("Persister doesn't support retention policies, so none is applied")
+			vessel.retention = nil
+		}
+	}
+	if _, ok := vessel.persister.(PresencePersister); !ok && opts.Presence != nil {
+		vessel.log.errorf("Persister doesn't support presence, so it's disabled")
+		vessel.presence = nil
 	}
 	httpHandler := newHTTPHandler(vessel)
+	httpHandler.marshaler = vessel.marshaler
+	httpHandler.authenticator = opts.Authenticator
+	httpHandler.authorizer = opts.Authorizer
+	httpHandler.stop = vessel.stop
+	httpHandler.log = vessel.log
+	httpHandler.router = vessel
 	vessel.httpHandler = httpHandler
+	vessel.sockjsHandler = sockjs.NewHandler(uri, sockjs.DefaultOptions, vessel.handler())
+	if opts.Authenticator != nil {
+		vessel.sessionAuth = newSessionAuthenticator(uri, opts.Authenticator, vessel.sockjsHandler)
+		vessel.sockjsHandler = vessel.sessionAuth
+	}
+	vessel.sockjsHandler = &originGuard{opts.CORS, vessel.sockjsHandler}
+	vessel.websocketHandler = newWebsocketHandler(vessel)
 	return vessel
 }
 
 // AddChannel registers the Channel handler with the specified name.
 func (v *sockjsVessel) AddChannel(name string, channel Channel) {
-	v.channels[name] = channel
+	v.AddHandler(name, AdaptChannel(channel))
 }
 
-// Start will start the server on the given port.
+// AddHandler registers the Handler with the specified name. The name may be
+// a pattern such as "orders.*" or "orders.>" to handle every channel it
+// matches, with the most specific handler for a channel winning. It may be
+// called while the Vessel is serving clients.
+func (v *sockjsVessel) AddHandler(name string, handler Handler) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.handlers[name]; !ok && isPattern(name) {
+		v.patterns = append(v.patterns, name)
+		sortPatterns(v.patterns)
+	}
+	v.handlers[name] = handler
+}
+
+// lookupHandler returns the Handler registered for the channel, or else the
+// one registered for the most specific pattern matching it. The caller must
+// hold the lock.
+func (v *sockjsVessel) lookupHandler(channel string) (Handler, bool) {
+	if handler, ok := v.handlers[channel]; ok {
+		return handler, true
+	}
+	for _, pattern := range v.patterns {
+		if MatchChannel(pattern, channel) {
+			return v.handlers[pattern], true
+		}
+	}
+	return nil, false
+}
+
+// Start will start the server on the given ports, serving SockJS and native
+// WebSockets on the first and the HTTP polling API and Server-Sent Events on
+// the second, over TLS if configured. It's a convenience for serving the
+// Vessel on its own; use Handler to mount it on an existing server instead.
 func (v *sockjsVessel) Start(sockPortStr, httpPortStr string) error {
+	v.mu.RLock()
+	closing := v.closing
+	v.mu.RUnlock()
+	if closing {
+		return fmt.Errorf("Vessel is shut down")
+	}
+
+	var tlsConfig *tls.Config
+	if v.tls != nil {
+		var err error
+		if tlsConfig, err = v.tls.build(); err != nil {
+			return err
+		}
+	}
+
+	if err := v.Prepare(); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	if v.closing {
+		v.mu.Unlock()
+		return fmt.Errorf("Vessel is shut down")
+	}
+	v.servers = []*http.Server{
+		&http.Server{Addr: sockPortStr, Handler: v.socketHandler(), TLSConfig: tlsConfig},
+		&http.Server{Addr: httpPortStr, Handler: v.restHandler(), TLSConfig: tlsConfig},
+	}
+	servers := v.servers
+	v.mu.Unlock()
+
+	errc := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if server.TLSConfig != nil {
+				// Certificates are already loaded into the TLSConfig.
+				errc <- server.ListenAndServeTLS("", "")
+			} else {
+				errc <- server.ListenAndServe()
+			}
+		}(server)
+	}
+
+	// If either server fails, stop the other so Start doesn't return while
+	// half of the Vessel is still running.
+	err := <-errc
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	for _, server := range servers {
+		server.Close()
+	}
+	return err
+}
+
+// Prepare readies the Persister, subscribes to the Backplane if any, starts
+// sending presence heartbeats if presence is enabled and starts trimming the
+// Persister if there is a retention policy. It must be called before serving
+// the Vessel's Handler. Once a call succeeds, later calls do nothing; after a
+// failed call, the next one tries again.
+func (v *sockjsVessel) Prepare() error {
+	v.prepareMu.Lock()
+	defer v.prepareMu.Unlock()
+	if v.prepared {
+		return nil
+	}
+	if err := v.prepare(); err != nil {
+		return err
+	}
+	v.prepared = true
+	return nil
+}
+
+// prepare does the work of Prepare. Background work is only started once
+// every step which can fail has succeeded, so a failed attempt can be
+// retried.
+func (v *sockjsVessel) prepare() error {
 	if err := v.persister.Prepare(); err != nil {
 		return err
 	}
+	if v.presence != nil {
+		if err := v.heartbeat(presenceHeartbeatInterval); err != nil {
+			return err
+		}
+	}
+	if v.backplane != nil {
+		if err := v.backplane.Subscribe(v.receive); err != nil {
+			return err
+		}
+	}
+	if v.presence != nil {
+		go v.heartbeats(presenceHeartbeatInterval)
+	}
+	if v.retention != nil {
+		go v.trim()
+	}
+	return nil
+}
+
+// Handler returns an http.Handler which serves the SockJS endpoints, native
+// WebSockets, Server-Sent Events and the HTTP polling API under the Vessel's
+// URI so that it can be mounted on any server or router.
+func (v *sockjsVessel) Handler() http.Handler {
+	rest := v.restHandler()
+	r := mux.NewRouter()
+	r.Handle(v.uri, rest).Methods("POST", "OPTIONS")
+	r.Handle(v.uri+"/message/{id}", rest)
+	r.Handle(v.uri+"/channel/{channel}", rest)
+	r.Handle(v.uri+"/presence/{channel}", rest)
+	r.Handle(v.uri+eventsPath, rest)
+	r.Handle(v.uri+websocketPath, v.websocketHandler)
+	r.Handle(v.uri, v.sockjsHandler)
+	r.PathPrefix(v.uri + "/").Handler(v.sockjsHandler)
+	return r
+}
 
-	sockjsHandler := sockjs.NewHandler(v.uri, sockjs.DefaultOptions, v.handler())
+// socketHandler returns an http.Handler which serves native WebSockets and
+// SockJS.
+func (v *sockjsVessel) socketHandler() http.Handler {
+	r := mux.NewRouter()
+	r.Handle(v.uri+websocketPath, v.websocketHandler)
+	r.Handle(v.uri, v.sockjsHandler)
+	r.PathPrefix(v.uri + "/").Handler(v.sockjsHandler)
+	return r
+}
+
+// restHandler returns an http.Handler which serves the HTTP polling API and
+// Server-Sent Events.
+func (v *sockjsVessel) restHandler() http.Handler {
 	r := mux.NewRouter()
 	r.HandleFunc(v.uri, v.httpHandler.send).Methods("POST")
 	r.HandleFunc(v.uri+"/message/{id}", v.httpHandler.pollResponses).Methods("GET")
 	r.HandleFunc(v.uri+"/channel/{channel}", v.httpHandler.pollSubscription).Methods("GET")
-	http.Handle("/", &httpServer{r})
+	r.HandleFunc(v.uri+"/presence/{channel}", v.httpHandler.presence).Methods("GET")
+	r.Handle(v.uri+eventsPath, &sseHandler{vessel: v}).Methods("GET")
+	return &httpServer{r: r, cors: v.cors}
+}
+
+// Shutdown gracefully stops the Vessel. It stops accepting connections and
+// messages, waits for in-flight handlers and dispatchers to finish, notifies
+// connected sessions and closes the Backplane and Persister. If the Context is
+// done before handlers finish, they're cancelled and the Context's error is
+// returned.
+func (v *sockjsVessel) Shutdown(ctx context.Context) error {
+	v.mu.Lock()
+	if v.closing {
+		v.mu.Unlock()
+		return fmt.Errorf("Vessel is already shut down")
+	}
+	v.closing = true
+	servers := v.servers
+	v.mu.Unlock()
+
+	close(v.stop)
+
+	// Stop accepting connections. Shutting down waits for open connections
+	// to go idle, which happens once sessions are closed below.
+	errc := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errc <- server.Shutdown(ctx)
+		}(server)
+	}
+
+	var err error
+	waited := make(chan struct{})
 	go func() {
-		http.ListenAndServe(httpPortStr, nil)
+		v.wg.Wait()
+		v.httpHandler.close()
+		close(waited)
 	}()
-	return http.ListenAndServe(sockPortStr, sockjsHandler)
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		v.cancel()
+		err = ctx.Err()
+	}
+
+	for _, session := range v.sessions.all() {
+		// Leave before closing so the Persister is still open.
+		v.leaveAll(session)
+		session.Close(shutdownStatus, shutdownReason)
+	}
+
+	for range servers {
+		if serverErr := <-errc; serverErr != nil && err == nil {
+			err = serverErr
+		}
+	}
+
+	v.cancel()
+	if v.backplane != nil {
+		if closeErr := v.backplane.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if closer, ok := v.persister.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// trim periodically removes results and messages which fall outside the
+// retention policy until the Vessel is shut down.
+func (v *sockjsVessel) trim() {
+	persister, ok := v.persister.(RetentionPersister)
+	if !ok {
+		return
+	}
+	ticker := time.NewTicker(v.trimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := persister.Trim(); err != nil {
+				v.log.errorf("Failed to trim persister: %s", err)
+			}
+		case <-v.stop:
+			return
+		}
+	}
 }
 
 // Persister returns the Persister for this Vessel.
@@ -69,94 +503,311 @@ func (v *sockjsVessel) URI() string {
 	return v.uri
 }
 
-// Broadcast sends the specified message on the given channel to all connected clients.
+// Broadcast sends the specified message on the given channel to all clients
+// subscribed to it or to a pattern matching it, including those connected to
+// other nodes if there's a Backplane. Messages can't be broadcast to a
+// pattern.
 func (s *sockjsVessel) Broadcast(channel string, msg string) {
+	if isPattern(channel) {
+		s.log.errorf("Cannot broadcast to pattern %s", channel)
+		return
+	}
 	m := s.messageGenerator(s.idGenerator(), channel, msg)
 
 	s.persister.SaveMessage(channel, m)
-	for _, session := range s.sessions {
-		if send, err := s.marshaler.marshal(m); err != nil {
-			log.Println(err)
-		} else {
-			sendStr := string(send)
-			log.Println("Send", sendStr)
-			session.Send(sendStr)
+	// Local clients are sent the message right away. The copy relayed back
+	// by the Backplane is recognized by its ID and dropped.
+	s.deliver(m)
+	if s.backplane != nil {
+		s.publish(&envelope{Kind: envelopeBroadcast, Message: m})
+	}
+}
+
+// deliver sends a broadcast message to the sessions connected to this node
+// which are subscribed to its channel and wakes polls waiting on it. Messages
+// which have already been delivered are ignored.
+func (s *sockjsVessel) deliver(m *Message) {
+	if !s.recent.add(m.ID) {
+		return
+	}
+
+	channel := m.Channel
+	s.httpHandler.notifier.notifyChannel(channel)
+	send, err := s.marshaler.Marshal(m)
+	if err != nil {
+		s.log.errorf("Failed to marshal broadcast on %s: %s", channel, err)
+		return
+	}
+
+	sendStr := string(send)
+	for _, session := range s.sessions.all() {
+		if !session.subscribed(channel) {
+			continue
+		}
+		if !s.authorize(session.getPrincipal(), ActionSubscribe, channel) {
+			continue
 		}
+		s.log.debugf("Send %s", sendStr)
+		session.Send(sendStr)
 	}
 }
 
 func (s *sockjsVessel) handler() func(sockjs.Session) {
-	return func(session sockjs.Session) {
-		s.sessions = append(s.sessions, session)
-
-		for {
-			msg, err := session.Recv()
-			if err != nil {
-				log.Println(err)
-				break
-			}
+	return func(sockjsSession sockjs.Session) {
+		var principal *Principal
+		if s.sessionAuth != nil {
+			// The client may have presented a token when connecting.
+			principal = s.sessionAuth.claim(sockjsSession.ID())
+		}
 
-			recvMsg, err := s.marshaler.unmarshal([]byte(msg))
-			if err != nil {
-				log.Println(err)
-				continue
-			}
+		s.serve(sockjsSession, principal, map[string]string{"transport": "sockjs"})
 
-			// Process message and invoke handler for it.
-			results, done, err := s.Recv(recvMsg)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
+		if s.sessionAuth != nil {
+			s.sessionAuth.claim(sockjsSession.ID())
+		}
+	}
+}
 
-			// Begin dispatching results produced by the handler.
-			go s.dispatchResponses(recvMsg.ID, recvMsg.Channel, results, done, session)
+// serve handles messages received on the session until the client
+// disconnects. The Principal is nil if the client hasn't authenticated yet.
+// The metadata describes the connection and is passed to handlers.
+func (s *sockjsVessel) serve(sockjsSession sockjs.Session, principal *Principal,
+	metadata map[string]string) {
+
+	session := newSession(s.ctx, sockjsSession)
+	session.metadata = metadata
+	if principal != nil {
+		session.setPrincipal(principal)
+	}
+	if !s.sessions.add(session) {
+		s.log.infof("Rejected session %s: ID in use", session.ID())
+		session.cancel()
+		session.Close(duplicateStatus, duplicateReason)
+		return
+	}
+	s.deliverQueued(session)
+
+	for {
+		msg, err := session.Recv()
+		if err != nil {
+			s.log.infof("Session closed: %s", err)
+			break
 		}
 
-		// Remove session from Vessel.
-		for i, sess := range s.sessions {
-			if sess == session {
-				s.sessions = append(s.sessions[:i], s.sessions[i+1:]...)
-				break
-			}
+		recvMsg, err := s.marshaler.Unmarshal([]byte(msg))
+		if err != nil {
+			s.log.infof("Bad message: %s", err)
+			continue
 		}
+
+		// Clients must authenticate before sending anything else.
+		if recvMsg.Channel != authChannel && recvMsg.Channel != doneChannel &&
+			!s.authenticated(session) {
+			s.log.infof("Unauthenticated message on session %s", session.ID())
+			session.Close(unauthorizedStatus, unauthorizedReason)
+			continue
+		}
+
+		// Authentication and subscription requests are handled by the
+		// Vessel itself.
+		if s.control(session, recvMsg) {
+			continue
+		}
+
+		// Process message and invoke handler for it.
+		req := newRequest(recvMsg, session.ID(), session.getPrincipal(), copyMetadata(metadata))
+		results, done, err := s.RecvRequest(session.ctx, req)
+		if err != nil {
+			s.log.infof("Rejected message %s on session %s: %s", recvMsg.ID, session.ID(), err)
+			s.sendDone(session, recvMsg.ID)
+			continue
+		}
+
+		// Begin dispatching results produced by the handler.
+		s.track(func() {
+			s.dispatchResponses(recvMsg.ID, recvMsg.Channel, results, done, session)
+		})
 	}
 
+	// Cancel handlers and stop dispatching responses to the session.
+	session.cancel()
+	s.sessions.remove(session)
+	s.leaveAll(session)
 }
 
-// Recv will handle a message by invoking any registered Channel handler. It
-// returns channels for receiving responses and checking if the message handler
-// has completed.
-func (s *sockjsVessel) Recv(msg *message) (<-chan string, <-chan bool, error) {
-	log.Printf("Recv %s:%s:%s", msg.ID, msg.Channel, msg.Body)
+// authenticated indicates if the session may send messages. Sessions must
+// authenticate when the Vessel has an Authenticator.
+func (s *sockjsVessel) authenticated(session *session) bool {
+	return s.authenticator == nil || session.getPrincipal() != nil
+}
 
-	channelHandler, ok := s.channels[msg.Channel]
+// authorize indicates if the Principal may perform the Action on the channel.
+// Everything is allowed when the Vessel has no Authorizer.
+func (s *sockjsVessel) authorize(principal *Principal, action Action, channel string) bool {
+	return s.authorizer == nil || s.authorizer.Authorize(principal, action, channel)
+}
+
+// control handles authentication, subscribe, unsubscribe and session ID
+// requests for the session. It returns true if the message was a control message, false
+// otherwise.
+func (s *sockjsVessel) control(session *session, msg *Message) bool {
+	switch msg.Channel {
+	case authChannel:
+		if s.authenticator == nil {
+			break
+		}
+		principal, err := s.authenticator.Authenticate(msg.Body)
+		if err != nil {
+			s.log.infof("Session failed to authenticate: %s", err)
+			session.Close(unauthorizedStatus, unauthorizedReason)
+			break
+		}
+		s.sessions.authenticate(session, principal)
+		s.deliverQueued(session)
+	case subscribeChannel:
+		if !s.authorize(session.getPrincipal(), ActionSubscribe, msg.Body) {
+			s.log.infof("Session %s not authorized to subscribe to %s", session.ID(), msg.Body)
+			break
+		}
+		if joined, ok := session.subscribe(msg.Body); ok {
+			s.join(session, msg.Body, joined)
+		}
+		if msg.Timestamp > 0 {
+			s.replay(session, msg.Body, msg.Timestamp)
+		}
+	case unsubscribeChannel:
+		if joined, ok := session.unsubscribe(msg.Body); ok {
+			s.leave(session, msg.Body, joined)
+		}
+	case sessionChannel:
+		s.replySessionID(session, msg)
+	case doneChannel:
+		session.setNotifyDone()
+	default:
+		return false
+	}
+	return true
+}
+
+// replay sends the session the messages broadcast on the channel since the
+// timestamp so resuming clients receive what they missed. Messages broadcast
+// while the history is sent may be received twice.
+func (s *sockjsVessel) replay(session *session, channel string, since int64) {
+	messages, err := s.persister.GetMessages(channel, since)
+	if err != nil {
+		s.log.errorf("Failed to get messages for channel %s: %s", channel, err)
+		return
+	}
+	messages = authorizedMessages(s.authorizer, session.getPrincipal(), ActionSubscribe, messages)
+	for _, msg := range messages {
+		send, err := s.marshaler.Marshal(msg)
+		if err != nil {
+			s.log.errorf("Failed to marshal message %s: %s", msg.ID, err)
+			continue
+		}
+		session.Send(string(send))
+	}
+}
+
+// Recv will handle a message by invoking any registered handler. It returns
+// channels for receiving responses and checking if the handler has completed.
+func (s *sockjsVessel) Recv(msg *Message) (<-chan string, <-chan bool, error) {
+	return s.RecvRequest(context.Background(), newRequest(msg, "", nil, nil))
+}
+
+// RecvRequest will handle a Request by invoking any registered handler with
+// the Context. It returns channels for receiving responses and checking if the
+// handler has completed.
+func (s *sockjsVessel) RecvRequest(ctx context.Context, req *Request) (<-chan string, <-chan bool, error) {
+	s.log.debugf("Recv %s:%s:%s", req.ID, req.Channel, req.Body)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if isPattern(req.Channel) {
+		return nil, nil, fmt.Errorf("Cannot send to pattern %s", req.Channel)
+	}
+	// Authorize before looking up the handler so unauthorized clients can't
+	// discover which channels are registered.
+	if !s.authorize(req.Principal, ActionSend, req.Channel) {
+		return nil, nil, ErrNotAuthorized
+	}
+
+	handler, ok := s.lookupHandler(req.Channel)
 	if !ok {
-		return nil, nil, fmt.Errorf("No channel registered for %s", msg.Channel)
+		return nil, nil, fmt.Errorf("No channel registered for %s", req.Channel)
+	}
+
+	if s.closing {
+		return nil, nil, fmt.Errorf("Vessel is shutting down")
+	}
+
+	var cancel context.CancelFunc
+	if s.handlerTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.handlerTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
 	}
 
+	// Handlers are also cancelled if the Vessel fails to shut down in time.
+	stop := context.AfterFunc(s.ctx, cancel)
+
 	result := make(chan string, 1)
 	done := make(chan bool, 1)
-	go channelHandler(msg.Body, result, done)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer stop()
+		defer cancel()
+		handler(ctx, req, result)
+		done <- true
+	}()
 	return result, done, nil
 }
 
+// track runs f in a goroutine which Shutdown waits for. It returns false
+// without running f once the Vessel is shutting down.
+func (s *sockjsVessel) track(f func()) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closing {
+		return false
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
+	return true
+}
+
 func (s *sockjsVessel) dispatchResponses(id, channel string, c <-chan string,
-	done <-chan bool, session sockjs.Session) {
+	done <-chan bool, session *session) {
 
-	for {
-		select {
-		case <-done:
-			return
-		case result := <-c:
-			sendMsg := newMessage(id, channel, result)
-			if send, err := s.marshaler.marshal(sendMsg); err != nil {
-				log.Println(err)
-			} else {
-				sendStr := string(send)
-				log.Println("Send", sendStr)
-				session.Send(sendStr)
-			}
+	completed := forward(c, done, session.ctx.Done(), func(result string) {
+		sendMsg := newMessage(id, channel, result)
+		if send, err := s.marshaler.Marshal(sendMsg); err != nil {
+			s.log.errorf("Failed to marshal response to %s: %s", id, err)
+		} else {
+			sendStr := string(send)
+			s.log.debugf("Send %s", sendStr)
+			session.Send(sendStr)
 		}
+	})
+	if completed {
+		s.sendDone(session, id)
+	}
+}
+
+// sendDone tells the session the handler of the message with the ID has
+// completed, if it asked to be told on the _done channel.
+func (s *sockjsVessel) sendDone(session *session, id string) {
+	if !session.notifiesDone() {
+		return
+	}
+	send, err := s.marshaler.Marshal(&Message{ID: id, Channel: doneChannel, Timestamp: time.Now().Unix()})
+	if err != nil {
+		s.log.errorf("Failed to marshal completion of %s: %s", id, err)
+		return
 	}
+	session.Send(string(send))
 }
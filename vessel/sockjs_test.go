@@ -1,8 +1,13 @@
 package vessel
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -36,8 +41,8 @@ func mockIDGenerator() string {
 	return "abc"
 }
 
-func mockMessageGenerator(id, channel, msg string) *message {
-	return &message{
+func mockMessageGenerator(id, channel, msg string) *Message {
+	return &Message{
 		ID:        id,
 		Channel:   channel,
 		Body:      msg,
@@ -59,6 +64,7 @@ func newChannel(t *testing.T, expected bool) Channel {
 func TestHandlerRecvError(t *testing.T) {
 	assert := assert.New(t)
 	session := new(mockSession)
+	session.On("ID").Return("123")
 	session.On("Recv").Return("", fmt.Errorf("error"))
 	vessel := NewSockJSVessel("http://localhost.com/foo")
 	vessel.AddChannel("foo", newChannel(t, false))
@@ -66,14 +72,32 @@ func TestHandlerRecvError(t *testing.T) {
 
 	handler(session)
 
-	assert.Equal(0, len(vessel.(*sockjsVessel).sessions))
+	assert.Equal(0, vessel.(*sockjsVessel).sessions.len())
 	session.Mock.AssertExpectations(t)
 }
 
+// Ensures that sockjsVessel handler closes a session whose ID is already
+// registered instead of replacing the registered session.
+func TestHandlerDuplicateID(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVessel("http://localhost.com/foo").(*sockjsVessel)
+	existing := addMockSession(vessel, "123", nil)
+	session := new(mockSession)
+	session.On("ID").Return("123")
+	session.On("Close", uint32(duplicateStatus), duplicateReason).Return(nil)
+
+	vessel.handler()(session)
+
+	assert.Equal(existing, vessel.sessions.get("123").Session)
+	session.Mock.AssertExpectations(t)
+	session.AssertNotCalled(t, "Recv")
+}
+
 // Ensures that sockjsVessel handler doesn't call Channel when unmarshal fails.
 func TestHandlerBadMessage(t *testing.T) {
 	assert := assert.New(t)
 	session := new(mockSession)
+	session.On("ID").Return("123")
 	session.On("Recv").Return(`{"foo": "bar"`, nil).Once()
 	session.On("Recv").Return("", fmt.Errorf("error")).Once()
 	vessel := NewSockJSVessel("http://localhost.com/foo")
@@ -82,7 +106,7 @@ func TestHandlerBadMessage(t *testing.T) {
 
 	handler(session)
 
-	assert.Equal(0, len(vessel.(*sockjsVessel).sessions))
+	assert.Equal(0, vessel.(*sockjsVessel).sessions.len())
 	session.Mock.AssertExpectations(t)
 }
 
@@ -90,6 +114,7 @@ func TestHandlerBadMessage(t *testing.T) {
 func TestHandlerChannel(t *testing.T) {
 	assert := assert.New(t)
 	session := new(mockSession)
+	session.On("ID").Return("123")
 	session.On("Recv").Return(`{"channel": "foo", "id": "abc", "body": "foobar"}`, nil).Once()
 	session.On("Recv").Return("", fmt.Errorf("error")).Once()
 	session.On("Send", `{"id":"abc","channel":"foo","body":"foo"}`).Return(nil)
@@ -99,13 +124,15 @@ func TestHandlerChannel(t *testing.T) {
 
 	handler(session)
 
-	assert.Equal(0, len(vessel.(*sockjsVessel).sessions))
+	assert.Equal(0, vessel.(*sockjsVessel).sessions.len())
 }
 
-// Ensures that Broadcast sends on all sessions.
+// Ensures that Broadcast sends on all subscribed sessions.
 func TestBroadcast(t *testing.T) {
 	session1 := new(mockSession)
 	session2 := new(mockSession)
+	session1.On("ID").Return("123")
+	session2.On("ID").Return("456")
 	session1.On("Send", `{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`).Return(nil)
 	session2.On("Send", `{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`).Return(nil)
 	vessel := NewSockJSVessel("http://localhost.com/foo")
@@ -113,8 +140,13 @@ func TestBroadcast(t *testing.T) {
 	vessel.(*sockjsVessel).persister = mockPersister
 	vessel.(*sockjsVessel).idGenerator = mockIDGenerator
 	vessel.(*sockjsVessel).messageGenerator = mockMessageGenerator
-	vessel.(*sockjsVessel).sessions = append(vessel.(*sockjsVessel).sessions, session1, session2)
-	mockPersister.On("SaveMessage", "foo", &message{
+	sess1 := newSession(context.Background(), session1)
+	sess1.subscribe("foo")
+	sess2 := newSession(context.Background(), session2)
+	sess2.subscribe("foo")
+	vessel.(*sockjsVessel).sessions.add(sess1)
+	vessel.(*sockjsVessel).sessions.add(sess2)
+	mockPersister.On("SaveMessage", "foo", &Message{
 		ID:        "abc",
 		Channel:   "foo",
 		Body:      "bar",
@@ -127,3 +159,508 @@ func TestBroadcast(t *testing.T) {
 	session2.Mock.AssertExpectations(t)
 	mockPersister.Mock.AssertExpectations(t)
 }
+
+// Ensures that Broadcast doesn't send on sessions which aren't subscribed to
+// the channel.
+func TestBroadcastNotSubscribed(t *testing.T) {
+	session1 := new(mockSession)
+	session2 := new(mockSession)
+	session1.On("ID").Return("123")
+	session2.On("ID").Return("456")
+	session1.On("Send", `{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`).Return(nil)
+	vessel := NewSockJSVessel("http://localhost.com/foo")
+	mockPersister := new(mockPersister)
+	vessel.(*sockjsVessel).persister = mockPersister
+	vessel.(*sockjsVessel).idGenerator = mockIDGenerator
+	vessel.(*sockjsVessel).messageGenerator = mockMessageGenerator
+	sess1 := newSession(context.Background(), session1)
+	sess1.subscribe("foo")
+	sess2 := newSession(context.Background(), session2)
+	sess2.subscribe("baz")
+	vessel.(*sockjsVessel).sessions.add(sess1)
+	vessel.(*sockjsVessel).sessions.add(sess2)
+	mockPersister.On("SaveMessage", "foo", &Message{
+		ID:        "abc",
+		Channel:   "foo",
+		Body:      "bar",
+		Timestamp: 1412003438,
+	}).Return(nil)
+
+	vessel.Broadcast("foo", "bar")
+
+	session1.Mock.AssertExpectations(t)
+	session2.Mock.AssertNotCalled(t, "Send", `{"id":"abc","channel":"foo","body":"bar","timestamp":1412003438}`)
+	mockPersister.Mock.AssertExpectations(t)
+}
+
+// Ensures that control handles subscribe and unsubscribe messages.
+func TestControl(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVessel("http://localhost.com/foo").(*sockjsVessel)
+	sess := newSession(context.Background(), new(mockSession))
+
+	assert.True(vessel.control(sess, &Message{ID: "abc", Channel: subscribeChannel, Body: "foo"}))
+	assert.True(sess.subscribed("foo"))
+
+	assert.True(vessel.control(sess, &Message{ID: "abc", Channel: unsubscribeChannel, Body: "foo"}))
+	assert.False(sess.subscribed("foo"))
+
+	assert.False(vessel.control(sess, &Message{ID: "abc", Channel: "foo", Body: "bar"}))
+}
+
+// Ensures that NewSockJSVesselWithOptions uses the provided Options.
+func TestNewSockJSVesselWithOptions(t *testing.T) {
+	assert := assert.New(t)
+	mockPersister := new(mockPersister)
+	marshaler := &jsonMarshaler{}
+
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{
+		Persister:   mockPersister,
+		Marshaler:   marshaler,
+		IDGenerator: mockIDGenerator,
+	}).(*sockjsVessel)
+
+	assert.Equal(mockPersister, vessel.Persister())
+	assert.Equal(marshaler, vessel.marshaler)
+	assert.Equal("abc", vessel.idGenerator())
+}
+
+// Ensures that a TrimInterval which isn't positive is replaced with the
+// default rather than making the trim ticker panic.
+func TestNewSockJSVesselWithOptionsTrimInterval(t *testing.T) {
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{
+		Persister:    new(mockPersister),
+		TrimInterval: -time.Second,
+	}).(*sockjsVessel)
+
+	assert.Equal(t, defaultTrimInterval, vessel.trimInterval)
+}
+
+// Ensures that NewSockJSVesselWithOptions uses defaults for unset Options.
+func TestNewSockJSVesselWithOptionsDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{
+		Redis: &RedisConfig{Addr: ":6380", DB: 2},
+	}).(*sockjsVessel)
+
+	if assert.IsType(&redisPersister{}, vessel.Persister()) {
+		config := vessel.Persister().(*redisPersister).config
+		assert.Equal(":6380", config.Addr)
+		assert.Equal(2, config.DB)
+	}
+	assert.IsType(&jsonMarshaler{}, vessel.marshaler)
+	assert.NotNil(vessel.idGenerator)
+}
+
+// Ensures that NewSockJSVesselWithOptions applies the retention policy to the
+// Persister.
+func TestNewSockJSVesselWithOptionsRetention(t *testing.T) {
+	mockPersister := new(mockPersister)
+	retention := RetentionPolicy{MaxMessages: 10}
+	mockPersister.On("SetRetention", retention).Return()
+
+	NewSockJSVesselWithOptions("/foo", &Options{
+		Persister: mockPersister,
+		Retention: &retention,
+	})
+
+	mockPersister.Mock.AssertExpectations(t)
+}
+
+// Ensures that dispatchResponses stops sending once the session has closed
+// and returns when the handler completes.
+func TestDispatchResponsesClosed(t *testing.T) {
+	session := new(mockSession)
+	vessel := NewSockJSVessel("http://localhost.com/foo").(*sockjsVessel)
+	sess := newSession(context.Background(), session)
+	sess.cancel()
+	results := make(chan string)
+	done := make(chan bool)
+	go func() {
+		results <- "foo"
+		results <- "bar"
+		done <- true
+	}()
+
+	vessel.dispatchResponses("abc", "foo", results, done, sess)
+
+	session.Mock.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+// Ensures that dispatchResponses tells sessions which asked on the _done
+// channel when the handler completes, after its responses.
+func TestDispatchResponsesDone(t *testing.T) {
+	assert := assert.New(t)
+	session := new(mockSession)
+	vessel := NewSockJSVessel("http://localhost.com/foo").(*sockjsVessel)
+	sess := newSession(context.Background(), session)
+	assert.True(vessel.control(sess, &Message{ID: "x", Channel: doneChannel}))
+	var sent []*Message
+	session.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		msg, _ := vessel.marshaler.Unmarshal([]byte(args.String(0)))
+		sent = append(sent, msg)
+	}).Return(nil)
+	results := make(chan string, 1)
+	done := make(chan bool, 1)
+	results <- "bar"
+	done <- true
+
+	vessel.dispatchResponses("abc", "foo", results, done, sess)
+
+	if assert.Len(sent, 2) {
+		assert.Equal("foo", sent[0].Channel)
+		assert.Equal("bar", sent[0].Body)
+		assert.Equal("abc", sent[1].ID)
+		assert.Equal(doneChannel, sent[1].Channel)
+	}
+}
+
+// Ensures that RecvRequest invokes the registered Handler with the Request and
+// signals done when it returns.
+func TestRecv(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVessel("http://localhost.com/foo")
+	var received *Request
+	vessel.AddHandler("foo", func(ctx context.Context, req *Request, results chan<- string) {
+		received = req
+		results <- "bar"
+	})
+	req := &Request{ID: "abc", Channel: "foo", Body: "baz", Session: "123"}
+
+	results, done, err := vessel.RecvRequest(context.Background(), req)
+
+	assert.Nil(err)
+	assert.Equal("bar", <-results)
+	assert.True(<-done)
+	assert.Equal(req, received)
+}
+
+// Ensures that Recv invokes the registered Handler with a Request for the
+// message.
+func TestRecvMessage(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVessel("http://localhost.com/foo")
+	var received *Request
+	vessel.AddHandler("foo", func(ctx context.Context, req *Request, results chan<- string) {
+		received = req
+		results <- "bar"
+	})
+
+	results, done, err := vessel.Recv(&Message{ID: "abc", Channel: "foo", Body: "baz", Timestamp: 5})
+
+	assert.Nil(err)
+	assert.Equal("bar", <-results)
+	assert.True(<-done)
+	if assert.NotNil(received) {
+		assert.Equal("abc", received.ID)
+		assert.Equal("baz", received.Body)
+		assert.Equal(int64(5), received.Timestamp)
+	}
+}
+
+// Ensures that RecvRequest returns an error when no handler is registered.
+func TestRecvNoChannel(t *testing.T) {
+	vessel := NewSockJSVessel("http://localhost.com/foo")
+
+	_, _, err := vessel.RecvRequest(context.Background(), &Request{ID: "abc", Channel: "foo"})
+
+	assert.NotNil(t, err)
+}
+
+// Ensures that RecvRequest applies the handler timeout to the Context.
+func TestRecvHandlerTimeout(t *testing.T) {
+	vessel := NewSockJSVesselWithOptions("http://localhost.com/foo", &Options{
+		Persister:      new(mockPersister),
+		HandlerTimeout: time.Millisecond,
+	})
+	vessel.AddHandler("foo", func(ctx context.Context, req *Request, results chan<- string) {
+		<-ctx.Done()
+	})
+
+	_, done, err := vessel.RecvRequest(context.Background(), &Request{ID: "abc", Channel: "foo"})
+
+	assert.Nil(t, err)
+	assert.True(t, <-done)
+}
+
+// Ensures that Shutdown waits for in-flight handlers, closes sessions and the
+// Persister, and rejects new messages.
+func TestShutdown(t *testing.T) {
+	assert := assert.New(t)
+	session := new(mockSession)
+	session.On("ID").Return("123")
+	session.On("Close", uint32(shutdownStatus), shutdownReason).Return(nil)
+	mockPersister := new(mockPersister)
+	mockPersister.On("Close").Return(nil)
+	vessel := NewSockJSVesselWithOptions("http://localhost.com/foo", &Options{
+		Persister: mockPersister,
+	}).(*sockjsVessel)
+	vessel.sessions.add(newSession(vessel.ctx, session))
+	finished := false
+	vessel.AddHandler("foo", func(ctx context.Context, req *Request, results chan<- string) {
+		time.Sleep(10 * time.Millisecond)
+		finished = true
+	})
+	_, _, err := vessel.RecvRequest(context.Background(), &Request{ID: "abc", Channel: "foo"})
+	assert.Nil(err)
+
+	err = vessel.Shutdown(context.Background())
+
+	assert.Nil(err)
+	assert.True(finished)
+	session.Mock.AssertExpectations(t)
+	mockPersister.Mock.AssertExpectations(t)
+	_, _, err = vessel.RecvRequest(context.Background(), &Request{ID: "def", Channel: "foo"})
+	assert.NotNil(err)
+}
+
+// Ensures that Shutdown cancels handlers which don't finish before the Context
+// is done and returns its error.
+func TestShutdownTimeout(t *testing.T) {
+	assert := assert.New(t)
+	mockPersister := new(mockPersister)
+	mockPersister.On("Close").Return(nil)
+	vessel := NewSockJSVesselWithOptions("http://localhost.com/foo", &Options{
+		Persister: mockPersister,
+	})
+	cancelled := make(chan bool, 1)
+	vessel.AddHandler("foo", func(ctx context.Context, req *Request, results chan<- string) {
+		<-ctx.Done()
+		cancelled <- true
+	})
+	vessel.RecvRequest(context.Background(), &Request{ID: "abc", Channel: "foo"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := vessel.Shutdown(ctx)
+
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.True(<-cancelled)
+	mockPersister.Mock.AssertExpectations(t)
+}
+
+// Ensures that Prepare only readies the Persister and subscribes to the
+// Backplane once, however many times it's called after succeeding.
+func TestPrepareOnce(t *testing.T) {
+	assert := assert.New(t)
+	persister := new(mockPersister)
+	persister.On("Prepare").Return(nil).Once()
+	backplane := new(mockBackplane)
+	backplane.On("Subscribe", mock.Anything).Return(nil).Once()
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{Persister: persister, Backplane: backplane})
+
+	assert.Nil(vessel.Prepare())
+	assert.Nil(vessel.Prepare())
+
+	persister.AssertExpectations(t)
+	backplane.AssertExpectations(t)
+}
+
+// Ensures that Prepare tries again after failing.
+func TestPrepareRetry(t *testing.T) {
+	assert := assert.New(t)
+	persister := new(mockPersister)
+	persister.On("Prepare").Return(nil).Twice()
+	backplane := new(mockBackplane)
+	backplane.On("Subscribe", mock.Anything).Return(fmt.Errorf("error")).Once()
+	backplane.On("Subscribe", mock.Anything).Return(nil).Once()
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{Persister: persister, Backplane: backplane})
+
+	assert.NotNil(vessel.Prepare())
+	assert.Nil(vessel.Prepare())
+	assert.Nil(vessel.Prepare())
+
+	persister.AssertExpectations(t)
+	backplane.AssertExpectations(t)
+}
+
+// Ensures that a Vessel which has been shut down can't be started and doesn't
+// dispatch new responses.
+func TestStartAfterShutdown(t *testing.T) {
+	assert := assert.New(t)
+	mockPersister := new(mockPersister)
+	mockPersister.On("Close").Return(nil)
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{Persister: mockPersister}).(*sockjsVessel)
+
+	assert.Nil(vessel.Shutdown(context.Background()))
+
+	assert.NotNil(vessel.Start(":0", ":0"))
+	assert.False(vessel.track(func() {}))
+	assert.False(vessel.httpHandler.track(func() {}))
+	mockPersister.AssertNotCalled(t, "Prepare")
+}
+
+// Ensures that Handler routes HTTP polling requests to the HTTP API and
+// everything else under the URI to SockJS.
+func TestHandler(t *testing.T) {
+	assert := assert.New(t)
+	mockPersister := new(mockPersister)
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{Persister: mockPersister}).(*sockjsVessel)
+	vessel.sockjsHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mockPersister.On("GetResult", "abc").Return(nil, fmt.Errorf("no result"))
+	handler := vessel.Handler()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://example.com/foo", strings.NewReader(`{}`))
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://example.com/foo/message/abc", nil)
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("OPTIONS", "http://example.com/foo", nil)
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://example.com/foo", nil)
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusTeapot, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "http://example.com/foo/abc/def/xhr", nil)
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusTeapot, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://example.com/foobar/info", nil)
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+// Ensures that sockjsVessel handler closes sessions which send messages
+// before authenticating.
+func TestHandlerUnauthenticated(t *testing.T) {
+	session := new(mockSession)
+	session.On("ID").Return("123")
+	session.On("Recv").Return(
+		`{"channel": "foo", "id": "abc", "body": "foobar", "timestamp": 1412003438}`, nil).Once()
+	session.On("Recv").Return("", fmt.Errorf("error")).Once()
+	session.On("Close", uint32(unauthorizedStatus), unauthorizedReason).Return(nil)
+	vessel := NewSockJSVesselWithOptions("http://localhost.com/foo", &Options{
+		Persister:     new(mockPersister),
+		Authenticator: AuthenticatorFunc(mockAuthenticator),
+	})
+	vessel.AddChannel("foo", newChannel(t, false))
+	handler := vessel.(*sockjsVessel).handler()
+
+	handler(session)
+
+	session.Mock.AssertExpectations(t)
+}
+
+// Ensures that control authenticates sessions with the token sent on the auth
+// channel and closes sessions with invalid tokens.
+func TestControlAuth(t *testing.T) {
+	assert := assert.New(t)
+	persister := new(mockPersister)
+	persister.On("TakeQueued", "user").Return(nil, nil)
+	vessel := NewSockJSVesselWithOptions("http://localhost.com/foo", &Options{
+		Persister:     persister,
+		Authenticator: AuthenticatorFunc(mockAuthenticator),
+	}).(*sockjsVessel)
+	sess := newSession(context.Background(), new(mockSession))
+
+	assert.False(vessel.authenticated(sess))
+	assert.True(vessel.control(sess, &Message{ID: "abc", Channel: authChannel, Body: "secret"}))
+	assert.True(vessel.authenticated(sess))
+	assert.Equal("user", sess.getPrincipal().ID)
+
+	session := new(mockSession)
+	session.On("Close", uint32(unauthorizedStatus), unauthorizedReason).Return(nil)
+	sess = newSession(context.Background(), session)
+	assert.True(vessel.control(sess, &Message{ID: "abc", Channel: authChannel, Body: "bad"}))
+	assert.False(vessel.authenticated(sess))
+	session.Mock.AssertExpectations(t)
+}
+
+// Ensures that RecvRequest rejects messages the client isn't authorized to send.
+func TestRecvNotAuthorized(t *testing.T) {
+	vessel := NewSockJSVesselWithOptions("http://localhost.com/foo", &Options{
+		Persister:  new(mockPersister),
+		Authorizer: Rules{{Pattern: "bar"}},
+	})
+	vessel.AddChannel("foo", newChannel(t, false))
+
+	_, _, err := vessel.RecvRequest(context.Background(), &Request{ID: "abc", Channel: "foo"})
+	assert.Equal(t, ErrNotAuthorized, err)
+
+	// Unregistered channels aren't revealed to unauthorized clients.
+	_, _, err = vessel.RecvRequest(context.Background(), &Request{ID: "def", Channel: "baz"})
+	assert.Equal(t, ErrNotAuthorized, err)
+}
+
+// Ensures that sessions can't subscribe to channels they aren't authorized to
+// subscribe to.
+func TestControlSubscribeNotAuthorized(t *testing.T) {
+	assert := assert.New(t)
+	session := new(mockSession)
+	session.On("ID").Return("123")
+	vessel := NewSockJSVesselWithOptions("http://localhost.com/foo", &Options{
+		Persister:  new(mockPersister),
+		Authorizer: Rules{{Pattern: "bar"}},
+	}).(*sockjsVessel)
+	sess := newSession(context.Background(), session)
+
+	assert.True(vessel.control(sess, &Message{ID: "abc", Channel: subscribeChannel, Body: "foo"}))
+	assert.False(sess.subscribed("foo"))
+	assert.True(vessel.control(sess, &Message{ID: "abc", Channel: subscribeChannel, Body: "bar"}))
+	assert.True(sess.subscribed("bar"))
+}
+
+// Ensures that subscribing with a timestamp replays the messages broadcast on
+// the channel since then.
+func TestControlSubscribeReplay(t *testing.T) {
+	assert := assert.New(t)
+	session := new(mockSession)
+	mockPersister := new(mockPersister)
+	vessel := NewSockJSVesselWithOptions("http://localhost.com/foo", &Options{
+		Persister: mockPersister,
+	}).(*sockjsVessel)
+	mockPersister.On("GetMessages", "bar", int64(1412003437)).Return(
+		[]*Message{&Message{ID: "def", Channel: "bar", Body: "baz", Timestamp: 1412003438}}, nil)
+	session.On("Send", `{"id":"def","channel":"bar","body":"baz","timestamp":1412003438}`).Return(nil)
+	sess := newSession(context.Background(), session)
+
+	assert.True(vessel.control(sess, &Message{ID: "abc", Channel: subscribeChannel, Body: "bar",
+		Timestamp: 1412003437}))
+
+	assert.True(sess.subscribed("bar"))
+	session.Mock.AssertExpectations(t)
+}
+
+// basicPersister only implements Persister, like Persisters written before
+// the optional interfaces were added.
+type basicPersister struct {
+	Persister
+}
+
+// Ensures that a Persister without the optional interfaces still serves
+// direct messages and disables presence and retention.
+func TestBasicPersister(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{
+		Persister: basicPersister{NewMemoryPersister(0)},
+		Presence:  &PresenceConfig{},
+		Retention: &RetentionPolicy{MaxMessages: 1},
+		LogLevel:  LogOff,
+	}).(*sockjsVessel)
+	session := addMockSession(vessel, "abc", &Principal{ID: "user"})
+	session.On("Close", uint32(shutdownStatus), shutdownReason).Return(nil)
+
+	assert.Nil(vessel.Prepare())
+	assert.Nil(vessel.SendToUser("user", "foo", "bar"))
+	session.AssertNumberOfCalls(t, "Send", 1)
+	assert.Nil(vessel.presence)
+	assert.Nil(vessel.retention)
+	members, err := vessel.Members("foo")
+	assert.Nil(err)
+	assert.Len(members, 0)
+	assert.Nil(vessel.Shutdown(context.Background()))
+}
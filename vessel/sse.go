@@ -0,0 +1,356 @@
+package vessel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventsPath is where Server-Sent Events clients subscribe, relative to
+	// the Vessel's URI.
+	eventsPath = "/events"
+
+	// lastEventIDParam is the query string parameter clients which can't set
+	// the Last-Event-ID header may resume with.
+	lastEventIDParam = "lastEventId"
+)
+
+var (
+	// sseKeepaliveInterval is how often a comment is written to idle event
+	// streams so proxies don't close them.
+	sseKeepaliveInterval = 15 * time.Second
+
+	// sseWriteTimeout bounds how long writing an event to a client may take,
+	// so a stalled client doesn't hold up broadcasts to everyone else.
+	sseWriteTimeout = 10 * time.Second
+)
+
+// errStreamClosed is returned by sseSession when the stream has ended.
+var errStreamClosed = errors.New("Event stream closed")
+
+// sseSession adapts a Server-Sent Events stream to the sockjs.Session
+// interface so it receives broadcasts like any other session. The stream is
+// one-way, so Recv blocks until it's closed. Each event's ID is the timestamp
+// of the newest message written followed by its ID, e.g. "1412003438:abc",
+// letting clients resume with Last-Event-ID without missing messages broadcast
+// in the same second.
+type sseSession struct {
+	id        string
+	w         http.ResponseWriter
+	flusher   http.Flusher
+	rc        *http.ResponseController
+	marshaler Marshaler
+	done      <-chan struct{}
+	closed    chan struct{}
+	once      sync.Once
+
+	// replayed holds the IDs of the history sent when the stream opened, so
+	// messages broadcast while it was being sent aren't written twice.
+	replayed      map[string]bool
+	replayedUntil int64
+
+	// cursor is the timestamp of the newest message written and cursorID
+	// is its ID.
+	cursor   int64
+	cursorID string
+	finished bool
+	mu       sync.Mutex
+}
+
+func newSSESession(id string, w http.ResponseWriter, flusher http.Flusher, marshaler Marshaler,
+	done <-chan struct{}) *sseSession {
+
+	return &sseSession{
+		id:        id,
+		w:         w,
+		flusher:   flusher,
+		rc:        http.NewResponseController(w),
+		marshaler: marshaler,
+		done:      done,
+		closed:    make(chan struct{}),
+		replayed:  map[string]bool{},
+	}
+}
+
+// ID returns the session's ID.
+func (s *sseSession) ID() string {
+	return s.id
+}
+
+// Recv blocks until the stream is closed since clients can't send on it.
+func (s *sseSession) Recv() (string, error) {
+	select {
+	case <-s.done:
+	case <-s.closed:
+	}
+	return "", errStreamClosed
+}
+
+// Send writes the message to the stream as an event.
+func (s *sseSession) Send(msg string) error {
+	m, err := s.marshaler.Unmarshal([]byte(msg))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.Timestamp <= s.replayedUntil && s.replayed[m.ID] {
+		return nil
+	}
+	return s.writeEvent(s.eventID(m), msg)
+}
+
+// Close ends the stream.
+func (s *sseSession) Close(status uint32, reason string) error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// finish ends the stream and stops writing to it once the request has
+// returned.
+func (s *sseSession) finish() {
+	s.Close(0, "")
+	s.mu.Lock()
+	s.finished = true
+	s.mu.Unlock()
+}
+
+// replay writes the messages to the stream and remembers them so they aren't
+// sent again if they're also broadcast to the session. The caller must hold
+// the lock.
+func (s *sseSession) replay(messages []*Message) error {
+	for _, msg := range messages {
+		data, err := s.marshaler.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if err := s.writeEvent(s.eventID(msg), string(data)); err != nil {
+			return err
+		}
+		s.replayed[msg.ID] = true
+		if msg.Timestamp > s.replayedUntil {
+			s.replayedUntil = msg.Timestamp
+		}
+	}
+	return nil
+}
+
+// keepalive writes a comment to the stream.
+func (s *sseSession) keepalive() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return errStreamClosed
+	}
+	return s.write(": keepalive\n\n")
+}
+
+// eventID advances the cursor to the message and returns it as an event ID.
+// Messages older than the cursor leave it where it is. The caller must hold
+// the lock.
+func (s *sseSession) eventID(m *Message) string {
+	if m.Timestamp >= s.cursor {
+		s.cursor = m.Timestamp
+		s.cursorID = m.ID
+	}
+	return strconv.FormatInt(s.cursor, 10) + ":" + s.cursorID
+}
+
+// writeEvent writes the data as an event with the given ID. The caller must
+// hold the lock.
+func (s *sseSession) writeEvent(id string, data string) error {
+	if s.finished {
+		return errStreamClosed
+	}
+
+	var event strings.Builder
+	fmt.Fprintf(&event, "id: %s\n", id)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&event, "data: %s\n", line)
+	}
+	event.WriteString("\n")
+	return s.write(event.String())
+}
+
+// write writes to the stream and flushes it within sseWriteTimeout. If the
+// write fails, the stream is closed so later writes don't wait on the client
+// again. The caller must hold the lock.
+func (s *sseSession) write(data string) error {
+	s.rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+	if _, err := fmt.Fprint(s.w, data); err != nil {
+		s.finished = true
+		s.Close(0, "")
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// sseHandler streams broadcasts on the channels given in the query string to
+// clients as Server-Sent Events.
+type sseHandler struct {
+	vessel *sockjsVessel
+}
+
+func (h *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Streaming not supported"))
+		return
+	}
+
+	principal, ok := h.vessel.httpHandler.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	channels := r.URL.Query()["channel"]
+	if len(channels) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No channel given"))
+		return
+	}
+	for _, channel := range channels {
+		if !h.vessel.httpHandler.authorize(w, principal, ActionSubscribe, channel) {
+			return
+		}
+	}
+
+	resume, since, lastID, err := lastEventID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	h.vessel.mu.RLock()
+	closing := h.vessel.closing
+	h.vessel.mu.RUnlock()
+	if closing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	stream := newSSESession(h.vessel.idGenerator(), w, flusher, h.vessel.marshaler, r.Context().Done())
+	session := newSession(h.vessel.ctx, stream)
+	session.metadata = requestMetadata(r, "sse")
+	if principal != nil {
+		session.setPrincipal(principal)
+	}
+	joined := map[string]time.Time{}
+	for _, channel := range channels {
+		if at, ok := session.subscribe(channel); ok {
+			joined[channel] = at
+		}
+	}
+
+	// Hold the stream while it's registered and the history is sent so
+	// broadcasts are written after the history.
+	stream.mu.Lock()
+	if !h.vessel.sessions.add(session) {
+		stream.mu.Unlock()
+		h.vessel.log.errorf("Rejected session %s: ID in use", session.ID())
+		session.cancel()
+		stream.finish()
+		return
+	}
+	if resume {
+		if err := stream.replay(h.history(principal, channels, since, lastID)); err != nil {
+			h.vessel.log.errorf("Failed to replay history: %s", err)
+		}
+	}
+	stream.mu.Unlock()
+	h.vessel.deliverQueued(session)
+	for channel, at := range joined {
+		h.vessel.join(session, channel, at)
+	}
+
+	ticker := time.NewTicker(sseKeepaliveInterval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			if err := stream.keepalive(); err != nil {
+				break loop
+			}
+		case <-stream.done:
+			break loop
+		case <-stream.closed:
+			break loop
+		}
+	}
+
+	session.cancel()
+	h.vessel.sessions.remove(session)
+	h.vessel.leaveAll(session)
+	stream.finish()
+}
+
+// history returns the messages on the channels since the timestamp which
+// the Principal may subscribe to, oldest first. Given the ID of the last
+// message the client received, the other messages with the timestamp are
+// included too, since the client may not have received them. Messages on
+// channels matched by more than one pattern are only returned once.
+func (h *sseHandler) history(principal *Principal, channels []string, since int64,
+	lastID string) []*Message {
+
+	from := since
+	seen := map[string]bool{}
+	if lastID != "" {
+		from = since - 1
+		seen[lastID] = true
+	}
+	messages := []*Message{}
+	added := map[string]bool{}
+	for _, channel := range channels {
+		channelMessages, err := h.vessel.persister.GetMessages(channel, from)
+		if err != nil {
+			h.vessel.log.errorf("Failed to get messages for channel %s: %s", channel, err)
+			continue
+		}
+		for _, msg := range unseenMessages(channelMessages, since, seen) {
+			if !added[msg.ID] {
+				added[msg.ID] = true
+				messages = append(messages, msg)
+			}
+		}
+	}
+	messages = authorizedMessages(h.vessel.authorizer, principal, ActionSubscribe, messages)
+	sortMessages(messages)
+	return messages
+}
+
+// lastEventID returns the timestamp and ID of the last message the client
+// received, given in the Last-Event-ID header or query string parameter, and
+// whether the client is resuming at all. An ID without a message, such as
+// "1412003438", resumes after every message with the timestamp.
+func lastEventID(r *http.Request) (bool, int64, string, error) {
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = r.URL.Query().Get(lastEventIDParam)
+	}
+	if id == "" {
+		return false, 0, "", nil
+	}
+
+	timestamp, messageID, _ := strings.Cut(id, ":")
+	since, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false, 0, "", fmt.Errorf("Invalid Last-Event-ID %s", id)
+	}
+	return true, since, messageID, nil
+}
@@ -0,0 +1,205 @@
+package vessel
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type event struct {
+	id  string
+	msg *Message
+}
+
+func openEventStream(t *testing.T, url string, header http.Header) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest("GET", url, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) *event {
+	e := &event{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && e.msg != nil:
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			e.msg = &Message{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), e.msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func sequentialMessageGenerator() messageGenerator {
+	var timestamp int64
+	return func(id, channel, msg string) *Message {
+		timestamp++
+		return &Message{ID: id, Channel: channel, Body: msg, Timestamp: timestamp}
+	}
+}
+
+// Ensures that the events endpoint streams broadcasts on the requested
+// channels.
+func TestSSEHandler(t *testing.T) {
+	assert := assert.New(t)
+	v := NewSockJSVesselWithOptions("/foo", &Options{Persister: NewMemoryPersister(0)})
+	v.(*sockjsVessel).messageGenerator = sequentialMessageGenerator()
+	server := httptest.NewServer(v.Handler())
+	defer server.Close()
+
+	// Resuming from the start guarantees the first broadcast is received
+	// whether it's sent before or after the stream is registered.
+	resp, r := openEventStream(t, server.URL+"/foo/events?channel=bar&channel=baz",
+		http.Header{"Last-Event-ID": {"0"}})
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	v.Broadcast("bar", "hello")
+	e := readEvent(t, r)
+	assert.Equal("1:"+e.msg.ID, e.id)
+	assert.Equal("bar", e.msg.Channel)
+	assert.Equal("hello", e.msg.Body)
+
+	v.Broadcast("qux", "ignored")
+	v.Broadcast("baz", "world")
+	e = readEvent(t, r)
+	assert.Equal("3:"+e.msg.ID, e.id)
+	assert.Equal("baz", e.msg.Channel)
+	assert.Equal("world", e.msg.Body)
+}
+
+// Ensures that the events endpoint replays the history since the
+// Last-Event-ID.
+func TestSSEHandlerResume(t *testing.T) {
+	assert := assert.New(t)
+	v := NewSockJSVesselWithOptions("/foo", &Options{Persister: NewMemoryPersister(0)})
+	v.(*sockjsVessel).messageGenerator = sequentialMessageGenerator()
+	server := httptest.NewServer(v.Handler())
+	defer server.Close()
+	v.Broadcast("bar", "a")
+	v.Broadcast("baz", "b")
+	v.Broadcast("bar", "c")
+
+	resp, r := openEventStream(t, server.URL+"/foo/events?channel=bar&channel=baz&lastEventId=1", nil)
+	defer resp.Body.Close()
+
+	assert.Equal("b", readEvent(t, r).msg.Body)
+	assert.Equal("c", readEvent(t, r).msg.Body)
+}
+
+// Ensures that resuming from an event ID only skips the message it names, so
+// messages broadcast in the same second aren't lost.
+func TestSSEHandlerResumeSameSecond(t *testing.T) {
+	assert := assert.New(t)
+	v := NewSockJSVesselWithOptions("/foo", &Options{Persister: NewMemoryPersister(0)})
+	v.(*sockjsVessel).messageGenerator = func(id, channel, msg string) *Message {
+		return &Message{ID: msg, Channel: channel, Body: msg, Timestamp: 5}
+	}
+	server := httptest.NewServer(v.Handler())
+	defer server.Close()
+	v.Broadcast("bar", "a")
+	v.Broadcast("bar", "b")
+	v.Broadcast("bar", "c")
+
+	resp, r := openEventStream(t, server.URL+"/foo/events?channel=bar",
+		http.Header{"Last-Event-ID": {"5:b"}})
+	defer resp.Body.Close()
+
+	e := readEvent(t, r)
+	assert.Equal("a", e.msg.Body)
+	assert.Equal("5:a", e.id)
+	e = readEvent(t, r)
+	assert.Equal("c", e.msg.Body)
+	assert.Equal("5:c", e.id)
+}
+
+// Ensures that the events endpoint rejects bad requests and channels the
+// client isn't authorized to subscribe to.
+func TestSSEHandlerErrors(t *testing.T) {
+	assert := assert.New(t)
+	v := NewSockJSVesselWithOptions("/foo", &Options{
+		Persister:  NewMemoryPersister(0),
+		Authorizer: Rules{{Pattern: "bar"}},
+	})
+	server := httptest.NewServer(v.Handler())
+	defer server.Close()
+
+	resp, _ := openEventStream(t, server.URL+"/foo/events", nil)
+	resp.Body.Close()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = openEventStream(t, server.URL+"/foo/events?channel=bar&channel=baz", nil)
+	resp.Body.Close()
+	assert.Equal(http.StatusForbidden, resp.StatusCode)
+
+	resp, _ = openEventStream(t, server.URL+"/foo/events?channel=bar", http.Header{"Last-Event-ID": {"x"}})
+	resp.Body.Close()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+// Ensures that sseSession writes multi-line data as multiple data fields and
+// doesn't write replayed messages twice.
+func TestSSESessionSend(t *testing.T) {
+	assert := assert.New(t)
+	w := httptest.NewRecorder()
+	stream := newSSESession("abc", w, w, &jsonMarshaler{}, nil)
+
+	stream.mu.Lock()
+	stream.replay([]*Message{{ID: "1", Channel: "foo", Body: "a", Timestamp: 5}})
+	stream.mu.Unlock()
+	assert.Nil(stream.Send(`{"id":"1","channel":"foo","body":"a","timestamp":5}`))
+	assert.Nil(stream.Send(`{"id":"2","channel":"foo","body":"b","timestamp":5}`))
+	assert.Nil(stream.writeEvent("6:3", "foo\nbar"))
+
+	assert.Equal("id: 5:1\ndata: {\"id\":\"1\",\"channel\":\"foo\",\"body\":\"a\",\"timestamp\":5}\n\n"+
+		"id: 5:2\ndata: {\"id\":\"2\",\"channel\":\"foo\",\"body\":\"b\",\"timestamp\":5}\n\n"+
+		"id: 6:3\ndata: foo\ndata: bar\n\n", w.Body.String())
+
+	stream.finish()
+	assert.Equal(errStreamClosed, stream.Send(`{"id":"2","channel":"foo","body":"b","timestamp":7}`))
+}
+
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("timeout")
+}
+
+// Ensures that a stream whose client fails to keep up is closed, so later
+// broadcasts don't wait on it.
+func TestSSESessionSendFails(t *testing.T) {
+	assert := assert.New(t)
+	w := failingWriter{httptest.NewRecorder()}
+	done := make(chan struct{})
+	stream := newSSESession("abc", w, w, &jsonMarshaler{}, done)
+
+	assert.NotNil(stream.Send(`{"id":"1","channel":"foo","body":"a","timestamp":5}`))
+
+	assert.Equal(errStreamClosed, stream.Send(`{"id":"2","channel":"foo","body":"b","timestamp":6}`))
+	_, err := stream.Recv()
+	assert.Equal(errStreamClosed, err)
+}
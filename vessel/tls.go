@@ -0,0 +1,62 @@
+package vessel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSConfig configures TLS for both of the Vessel's listeners.
+type TLSConfig struct {
+	// CertFile and KeyFile are the paths of the PEM-encoded server certificate
+	// and its private key.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the path of a PEM-encoded bundle of certificate
+	// authorities. If set, clients must present a certificate signed by one
+	// of them.
+	ClientCAFile string
+
+	// Config is used as the base configuration if set. Certificates and
+	// client CAs loaded from the files above are added to a copy of it.
+	Config *tls.Config
+}
+
+// build returns the tls.Config described by the TLSConfig.
+func (t *TLSConfig) build() (*tls.Config, error) {
+	var config *tls.Config
+	if t.Config != nil {
+		config = t.Config.Clone()
+	} else {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		return nil, fmt.Errorf("TLS requires a certificate")
+	}
+
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", t.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
@@ -0,0 +1,109 @@
+package vessel
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert writes a self-signed certificate and its key to dir and returns
+// their paths.
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// Ensures that build loads the certificate and key files.
+func TestTLSConfigBuild(t *testing.T) {
+	assert := assert.New(t)
+	dir, _ := os.MkdirTemp("", "vessel")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir)
+
+	config, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile}).build()
+
+	assert.Nil(err)
+	if assert.NotNil(config) {
+		assert.Equal(1, len(config.Certificates))
+		assert.Equal(tls.NoClientCert, config.ClientAuth)
+	}
+}
+
+// Ensures that build requires client certificates when a client CA is set.
+func TestTLSConfigBuildClientCA(t *testing.T) {
+	assert := assert.New(t)
+	dir, _ := os.MkdirTemp("", "vessel")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir)
+
+	config, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile}).build()
+
+	assert.Nil(err)
+	if assert.NotNil(config) {
+		assert.NotNil(config.ClientCAs)
+		assert.Equal(tls.RequireAndVerifyClientCert, config.ClientAuth)
+	}
+}
+
+// Ensures that build returns an error when there is no certificate.
+func TestTLSConfigBuildNoCertificate(t *testing.T) {
+	config, err := (&TLSConfig{}).build()
+
+	assert.Nil(t, config)
+	assert.NotNil(t, err)
+}
+
+// Ensures that build returns an error when the client CA file has no
+// certificates.
+func TestTLSConfigBuildBadClientCA(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "vessel")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir)
+
+	config, err := (&TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile}).build()
+
+	assert.Nil(t, config)
+	assert.NotNil(t, err)
+}
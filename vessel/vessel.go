@@ -1,35 +1,182 @@
 package vessel
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/mattrobenolt/gocql/uuid"
 )
 
+const (
+	defaultTrimInterval = time.Minute
+
+	// shutdownStatus and shutdownReason are sent to sessions when they're
+	// closed because the Vessel is shutting down.
+	shutdownStatus = 3000
+	shutdownReason = "Server shutting down"
+
+	// duplicateStatus and duplicateReason are sent to sessions when they're
+	// closed because another session with the same ID is connected.
+	duplicateStatus = 3002
+	duplicateReason = "Session ID in use"
+
+	// subscribeChannel is the reserved channel clients send on to subscribe
+	// to the channel named in the message body.
+	subscribeChannel = "_subscribe"
+
+	// unsubscribeChannel is the reserved channel clients send on to
+	// unsubscribe from the channel named in the message body.
+	unsubscribeChannel = "_unsubscribe"
+
+	// doneChannel is the reserved channel clients send on to be told when
+	// the handlers of the messages they send complete. Each completion is
+	// then sent on it with the ID of the message handled.
+	doneChannel = "_done"
+)
+
 // Channel is a function which takes a message, a channel for sending results, and a channel
 // for signaling that the handler has completed.
 type Channel func(string, chan<- string, chan<- bool)
 
+// Handler is a function which handles a Request, sending any results on the
+// provided channel. The handler has completed when it returns. The Context is
+// cancelled when the client disconnects or the handler's deadline passes, so
+// handlers should stop work and return once it's done.
+type Handler func(context.Context, *Request, chan<- string)
+
+// Request is a message received on a channel along with details about the
+// client which sent it.
+type Request struct {
+	// ID is the ID of the message.
+	ID string
+
+	// Channel is the name of the channel the message was sent on.
+	Channel string
+
+	// Body is the message body.
+	Body string
+
+	// Timestamp is when the client sent the message.
+	Timestamp int64
+
+	// Session identifies the client session the message was received on. It's
+	// empty for messages sent over HTTP.
+	Session string
+
+	// Principal is the authenticated client which sent the message. It's nil
+	// if the Vessel has no Authenticator.
+	Principal *Principal
+
+	// Metadata contains additional information about the client, such as its
+	// remote address when sent over HTTP.
+	Metadata map[string]string
+}
+
+// newRequest returns a Request for the message received on the session.
+func newRequest(msg *Message, session string, principal *Principal,
+	metadata map[string]string) *Request {
+
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return &Request{
+		ID:        msg.ID,
+		Channel:   msg.Channel,
+		Body:      msg.Body,
+		Timestamp: msg.Timestamp,
+		Session:   session,
+		Principal: principal,
+		Metadata:  metadata,
+	}
+}
+
+// AdaptChannel returns a Handler which invokes the Channel. Since a Channel
+// can't be cancelled, its remaining results are discarded once the Context is
+// done and the Handler returns without waiting for it to complete.
+func AdaptChannel(channel Channel) Handler {
+	return func(ctx context.Context, req *Request, results chan<- string) {
+		c := make(chan string)
+		done := make(chan bool, 1)
+		go channel(req.Body, c, done)
+		forward(c, done, ctx.Done(), func(result string) {
+			select {
+			case results <- result:
+			case <-ctx.Done():
+			}
+		})
+	}
+}
+
 // Vessel coordinates communication between clients and server. It's responsible for managing
 // Channels and processing incoming and outgoing messages.
 type Vessel interface {
 	// AddChannel registers the Channel handler with the specified name.
 	AddChannel(string, Channel)
 
-	// Start will start the server on the given ports.
+	// AddHandler registers the Handler with the specified name, which may be
+	// a pattern matching several channels.
+	AddHandler(string, Handler)
+
+	// Start will start the server on the given ports. It blocks until the
+	// Vessel is shut down or a server fails.
 	Start(string, string) error
 
-	// Recv will handle a message by invoking any registered Channel handler.
-	// It returns channels for receiving responses and checking if the message
-	// handler has completed.
-	Recv(*message) (<-chan string, <-chan bool, error)
+	// Prepare readies the Vessel to serve clients. It's called by Start and
+	// must be called before serving the Vessel's Handler. Calling it again
+	// has no effect once it has succeeded, and retries it if it failed.
+	Prepare() error
+
+	// Handler returns an http.Handler serving the Vessel's endpoints under
+	// its URI.
+	Handler() http.Handler
+
+	// Shutdown gracefully stops the Vessel, waiting for in-flight handlers
+	// until the Context is done.
+	Shutdown(context.Context) error
+
+	// Recv will handle a message by invoking any registered handler. It
+	// returns channels for receiving responses and checking if the handler
+	// has completed. It's RecvRequest without a Context or details about the
+	// client.
+	Recv(*Message) (<-chan string, <-chan bool, error)
 
-	// Broadcast sends the specified message on the given channel to all connected clients.
+	// RecvRequest will handle a Request by invoking any registered handler
+	// with the Context. It returns channels for receiving responses and
+	// checking if the handler has completed.
+	RecvRequest(context.Context, *Request) (<-chan string, <-chan bool, error)
+
+	// Broadcast sends the specified message on the given channel to all clients
+	// subscribed to it.
 	Broadcast(string, string)
 
+	// Sessions returns the sessions connected to this node.
+	Sessions() []*SessionInfo
+
+	// Session returns the session connected to this node with the ID.
+	Session(string) (*SessionInfo, bool)
+
+	// SessionsFor returns the sessions connected to this node which
+	// authenticated as the Principal with the ID.
+	SessionsFor(string) []*SessionInfo
+
+	// SendToSession sends a message on the channel to the session with the
+	// ID.
+	SendToSession(string, string, string) error
+
+	// SendToUser sends a message on the channel to every session of the user
+	// with the Principal ID, queueing it until they connect if they aren't.
+	SendToUser(string, string, string) error
+
+	// Members returns the sessions subscribed to the channel on every node
+	// when presence is enabled. Subscriptions to patterns aren't included.
+	Members(string) ([]*Member, error)
+
 	// Persister returns the Persister for this Vessel.
 	Persister() Persister
 
@@ -37,25 +184,153 @@ type Vessel interface {
 	URI() string
 }
 
+// Persister stores messages sent on channels and the results produced by
+// Channel handlers. Features which need more from the Persister rely on it
+// also implementing QueuePersister, PresencePersister or RetentionPersister,
+// and Persisters which implement io.Closer are closed when the Vessel shuts
+// down.
 type Persister interface {
 	Prepare() error
-	SaveResult(string, *result) error
-	SaveMessage(string, *message) error
-	GetResult(string) (*result, error)
-	GetMessages(string, int64) ([]*message, error)
+	SaveResult(string, *Result) error
+	SaveMessage(string, *Message) error
+	GetResult(string) (*Result, error)
+	GetMessages(string, int64) ([]*Message, error)
 }
 
-type message struct {
+// QueuePersister is a Persister which queues messages for users who aren't
+// connected. Without it, SendToUser only reaches the sessions connected at the
+// time.
+type QueuePersister interface {
+	Persister
+
+	// QueueMessage stores a message for the user with the ID until it's
+	// delivered to one of their sessions.
+	QueueMessage(string, *Message) error
+
+	// DeleteQueued removes the message with the ID from the user's queue
+	// once it has been delivered.
+	DeleteQueued(string, string) error
+
+	// TakeQueued removes and returns the messages queued for the user, oldest
+	// first.
+	TakeQueued(string) ([]*Message, error)
+}
+
+// PresencePersister is a Persister which tracks the members of channels. It's
+// required to enable presence.
+type PresencePersister interface {
+	Persister
+
+	// AddMember records that the session is subscribed to the channel.
+	AddMember(string, *Member) error
+
+	// RemoveMember removes the member with the ID from the channel's
+	// members.
+	RemoveMember(string, string) error
+
+	// GetMembers returns the sessions subscribed to the channel. Members of
+	// nodes which have stopped sending heartbeats are left out.
+	GetMembers(string) ([]*Member, error)
+
+	// Heartbeat records that the node with the ID is running for the
+	// duration. It's called periodically by Vessels tracking presence.
+	Heartbeat(string, time.Duration) error
+}
+
+// RetentionPersister is a Persister which applies a RetentionPolicy. It's
+// required to set one.
+type RetentionPersister interface {
+	Persister
+
+	// SetRetention sets the RetentionPolicy applied when saving and trimming.
+	// It's called before Prepare.
+	SetRetention(RetentionPolicy)
+
+	// Trim removes results and messages which fall outside the
+	// RetentionPolicy. It's called periodically by the Vessel.
+	Trim() error
+}
+
+// RetentionPolicy controls how long a Persister keeps results and messages.
+// Zero values mean no limit.
+type RetentionPolicy struct {
+	// ResultTTL is how long a result is kept after it was last saved.
+	ResultTTL time.Duration
+
+	// MessageTTL is how long a message is kept on its channel.
+	MessageTTL time.Duration
+
+	// MaxMessages is the maximum number of messages kept per channel. The
+	// oldest messages are discarded first.
+	MaxMessages int
+}
+
+// limitsMessages indicates if the policy restricts channel history.
+func (r RetentionPolicy) limitsMessages() bool {
+	return r.MessageTTL > 0 || r.MaxMessages > 0
+}
+
+// Message is the unit of communication between clients and server.
+type Message struct {
 	ID        string `json:"id"`
 	Channel   string `json:"channel"`
 	Body      string `json:"body"`
 	Timestamp int64  `json:"timestamp"`
 }
 
-type messageGenerator func(string, string, string) *message
+// sortMessages orders messages from several channels by timestamp,
+// preserving the order of those with the same timestamp.
+func sortMessages(messages []*Message) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp < messages[j].Timestamp
+	})
+}
+
+// forward calls send with each result produced by a Channel handler until the
+// handler signals it's done. Results sent before done are delivered in order
+// before forward returns. If cancel is closed first, the remaining results
+// are discarded until the handler is done so that it isn't left blocked
+// sending. It returns false if it was cancelled.
+func forward(results <-chan string, done <-chan bool, cancel <-chan struct{},
+	send func(string)) bool {
+
+	for {
+		select {
+		case <-done:
+			// The handler may have sent results which haven't been received
+			// yet, so drain them before returning.
+			for {
+				select {
+				case result := <-results:
+					send(result)
+				default:
+					return true
+				}
+			}
+		case result := <-results:
+			send(result)
+		case <-cancel:
+			go discard(results, done)
+			return false
+		}
+	}
+}
 
-func newMessage(id, channel, body string) *message {
-	return &message{
+// discard receives and drops results until the handler signals it's done.
+func discard(results <-chan string, done <-chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-results:
+		}
+	}
+}
+
+type messageGenerator func(string, string, string) *Message
+
+func newMessage(id, channel, body string) *Message {
+	return &Message{
 		ID:        id,
 		Channel:   channel,
 		Body:      body,
@@ -63,21 +338,108 @@ func newMessage(id, channel, body string) *message {
 	}
 }
 
-type idGenerator func() string
+// IDGenerator returns a unique ID for a server-generated message.
+type IDGenerator func() string
 
 func newUUID() string {
 	uuid := uuid.RandomUUID().String()
 	return strings.Replace(uuid, "-", "", -1)
 }
 
-type marshaler interface {
-	unmarshal([]byte) (*message, error)
-	marshal(*message) ([]byte, error)
+// Marshaler converts Messages to and from their wire format.
+type Marshaler interface {
+	Unmarshal([]byte) (*Message, error)
+	Marshal(*Message) ([]byte, error)
+}
+
+// Options configures a Vessel. Fields left unset are replaced with defaults.
+type Options struct {
+	// Persister stores messages and results. Defaults to a Redis Persister
+	// configured by Redis.
+	Persister Persister
+
+	// Redis configures the default Redis Persister. It's ignored if
+	// Persister is set.
+	Redis *RedisConfig
+
+	// Backplane relays broadcasts to the other nodes of a cluster if set, so
+	// clients connected to any node receive them. The nodes should share a
+	// Persister as well.
+	Backplane Backplane
+
+	// Presence tracks the sessions subscribed to each channel if set. The
+	// members are kept in the Persister so they're shared by every node, so
+	// it must be a PresencePersister.
+	Presence *PresenceConfig
+
+	// Marshaler converts messages to and from their wire format, including
+	// messages POSTed to the HTTP API. Defaults to JSON. The HTTP API's
+	// responses are always JSON.
+	Marshaler Marshaler
+
+	// IDGenerator generates IDs for broadcast messages. Defaults to UUIDs.
+	IDGenerator IDGenerator
+
+	// Retention is applied to the Persister if set, and the Persister is
+	// trimmed every TrimInterval while the Vessel is running. The Persister
+	// must be a RetentionPersister.
+	Retention *RetentionPolicy
+
+	// TrimInterval is how often the Persister is trimmed. Defaults to one
+	// minute if it isn't positive.
+	TrimInterval time.Duration
+
+	// HandlerTimeout is the deadline applied to the Context passed to
+	// handlers. Zero means no deadline.
+	HandlerTimeout time.Duration
+
+	// TLS serves both of the listeners started by Start over TLS if set.
+	TLS *TLSConfig
+
+	// Authenticator verifies clients if set. HTTP clients present a bearer
+	// token in the Authorization header or a token query parameter. SockJS
+	// clients present a token query parameter when connecting or send it on
+	// the _auth channel before any other message.
+	Authenticator Authenticator
+
+	// Authorizer decides which clients may send to, subscribe to and poll
+	// each channel if set. Rules provides a simple pattern-based Authorizer.
+	Authorizer Authorizer
+
+	// CORS controls which origins may access the HTTP API and SockJS
+	// endpoints. Defaults to DefaultCORSConfig.
+	CORS *CORSConfig
+
+	// LogLevel is the least severe level of log message written. Defaults to
+	// LogDebug.
+	LogLevel LogLevel
+
+	// Logger receives log messages. Defaults to the standard logger.
+	Logger *log.Logger
+}
+
+// setDefaults replaces unset fields with their default values.
+func (o *Options) setDefaults() {
+	if o.Persister == nil {
+		o.Persister = NewRedisPersister(o.Redis)
+	}
+	if o.Marshaler == nil {
+		o.Marshaler = &jsonMarshaler{}
+	}
+	if o.IDGenerator == nil {
+		o.IDGenerator = newUUID
+	}
+	if o.CORS == nil {
+		o.CORS = DefaultCORSConfig()
+	}
+	if o.TrimInterval <= 0 {
+		o.TrimInterval = defaultTrimInterval
+	}
 }
 
 type jsonMarshaler struct{}
 
-func (j *jsonMarshaler) unmarshal(msg []byte) (*message, error) {
+func (j *jsonMarshaler) Unmarshal(msg []byte) (*Message, error) {
 	var payload map[string]interface{}
 	if err := json.Unmarshal(msg, &payload); err != nil {
 		return nil, err
@@ -103,7 +465,7 @@ func (j *jsonMarshaler) unmarshal(msg []byte) (*message, error) {
 		return nil, fmt.Errorf("Message missing timestamp")
 	}
 
-	message := &message{
+	message := &Message{
 		ID:        id.(string),
 		Channel:   channel.(string),
 		Body:      body.(string),
@@ -113,6 +475,6 @@ func (j *jsonMarshaler) unmarshal(msg []byte) (*message, error) {
 	return message, nil
 }
 
-func (j *jsonMarshaler) marshal(message *message) ([]byte, error) {
+func (j *jsonMarshaler) Marshal(message *Message) ([]byte, error) {
 	return json.Marshal(message)
 }
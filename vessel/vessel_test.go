@@ -1,6 +1,8 @@
 package vessel
 
 import (
+	"context"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,11 +22,35 @@ func (m *mockVessel) Start(sockPortStr, httpPortStr string) error {
 	return args.Error(0)
 }
 
-func (m *mockVessel) Recv(msg *message) (<-chan string, <-chan bool, error) {
+func (m *mockVessel) Prepare() error {
+	args := m.Mock.Called()
+	return args.Error(0)
+}
+
+func (m *mockVessel) Handler() http.Handler {
+	args := m.Mock.Called()
+	return args.Get(0).(http.Handler)
+}
+
+func (m *mockVessel) Shutdown(ctx context.Context) error {
+	args := m.Mock.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *mockVessel) AddHandler(name string, handler Handler) {
+	m.Mock.Called(name, handler)
+}
+
+func (m *mockVessel) Recv(msg *Message) (<-chan string, <-chan bool, error) {
 	args := m.Mock.Called(msg)
 	return args.Get(0).(<-chan string), args.Get(1).(<-chan bool), args.Error(2)
 }
 
+func (m *mockVessel) RecvRequest(ctx context.Context, req *Request) (<-chan string, <-chan bool, error) {
+	args := m.Mock.Called(ctx, req)
+	return args.Get(0).(<-chan string), args.Get(1).(<-chan bool), args.Error(2)
+}
+
 func (m *mockVessel) Broadcast(channel string, msg string) {
 	m.Mock.Called(channel, msg)
 }
@@ -39,12 +65,46 @@ func (m *mockVessel) URI() string {
 	return args.String(0)
 }
 
+func (m *mockVessel) Sessions() []*SessionInfo {
+	args := m.Mock.Called()
+	return args.Get(0).([]*SessionInfo)
+}
+
+func (m *mockVessel) Session(id string) (*SessionInfo, bool) {
+	args := m.Mock.Called(id)
+	return args.Get(0).(*SessionInfo), args.Bool(1)
+}
+
+func (m *mockVessel) SessionsFor(principalID string) []*SessionInfo {
+	args := m.Mock.Called(principalID)
+	return args.Get(0).([]*SessionInfo)
+}
+
+func (m *mockVessel) SendToSession(sessionID, channel, body string) error {
+	args := m.Mock.Called(sessionID, channel, body)
+	return args.Error(0)
+}
+
+func (m *mockVessel) SendToUser(principalID, channel, body string) error {
+	args := m.Mock.Called(principalID, channel, body)
+	return args.Error(0)
+}
+
+func (m *mockVessel) Members(channel string) ([]*Member, error) {
+	args := m.Mock.Called(channel)
+	members := args.Get(0)
+	if members != nil {
+		return members.([]*Member), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 // Ensures that unmarshal returns nil and an error when the message is not valid JSON.
 func TestUnmarshalBadJSON(t *testing.T) {
 	assert := assert.New(t)
 	j := &jsonMarshaler{}
 
-	message, err := j.unmarshal([]byte(`{"foo":}`))
+	message, err := j.Unmarshal([]byte(`{"foo":}`))
 
 	assert.Nil(message)
 	assert.NotNil(err)
@@ -55,7 +115,7 @@ func TestUnmarshalMissingID(t *testing.T) {
 	assert := assert.New(t)
 	j := &jsonMarshaler{}
 
-	message, err := j.unmarshal([]byte(`{"channel": "foo", "body": "bar"}`))
+	message, err := j.Unmarshal([]byte(`{"channel": "foo", "body": "bar"}`))
 
 	assert.Nil(message)
 	assert.NotNil(err)
@@ -66,7 +126,7 @@ func TestUnmarshalMissingChannel(t *testing.T) {
 	assert := assert.New(t)
 	j := &jsonMarshaler{}
 
-	message, err := j.unmarshal([]byte(`{"id": "foo", "body": "bar"}`))
+	message, err := j.Unmarshal([]byte(`{"id": "foo", "body": "bar"}`))
 
 	assert.Nil(message)
 	assert.NotNil(err)
@@ -77,7 +137,7 @@ func TestUnmarshalMissingBody(t *testing.T) {
 	assert := assert.New(t)
 	j := &jsonMarshaler{}
 
-	message, err := j.unmarshal([]byte(`{"id": "foo", "channel": "bar"}`))
+	message, err := j.Unmarshal([]byte(`{"id": "foo", "channel": "bar"}`))
 
 	assert.Nil(message)
 	assert.NotNil(err)
@@ -88,7 +148,7 @@ func TestUnmarshalMissingTimestamp(t *testing.T) {
 	assert := assert.New(t)
 	j := &jsonMarshaler{}
 
-	message, err := j.unmarshal([]byte(`{"id": "abc", "channel": "foo", "body": "bar"}`))
+	message, err := j.Unmarshal([]byte(`{"id": "abc", "channel": "foo", "body": "bar"}`))
 
 	assert.Nil(message)
 	assert.NotNil(err)
@@ -99,7 +159,7 @@ func TestUnmarshalHappyPath(t *testing.T) {
 	assert := assert.New(t)
 	j := &jsonMarshaler{}
 
-	message, err := j.unmarshal(
+	message, err := j.Unmarshal(
 		[]byte(`{"id": "abc", "channel": "foo", "body": "bar", "timestamp": 1412003438}`))
 
 	if assert.NotNil(message) {
@@ -114,11 +174,86 @@ func TestUnmarshalHappyPath(t *testing.T) {
 func TestMarshal(t *testing.T) {
 	assert := assert.New(t)
 	j := &jsonMarshaler{}
-	message := &message{ID: "foo", Channel: "bar", Body: "baz", Timestamp: 1412003438}
+	message := &Message{ID: "foo", Channel: "bar", Body: "baz", Timestamp: 1412003438}
 
-	messageJSON, err := j.marshal(message)
+	messageJSON, err := j.Marshal(message)
 
 	assert.Equal(`{"id":"foo","channel":"bar","body":"baz","timestamp":1412003438}`,
 		string(messageJSON))
 	assert.Nil(err)
 }
+
+// Ensures that forward sends every result, including those still buffered
+// when the handler signals done, in order and then returns.
+func TestForward(t *testing.T) {
+	assert := assert.New(t)
+	results := make(chan string, 3)
+	done := make(chan bool, 1)
+	results <- "a"
+	results <- "b"
+	results <- "c"
+	done <- true
+	sent := []string{}
+
+	ok := forward(results, done, nil, func(result string) {
+		sent = append(sent, result)
+	})
+
+	assert.True(ok)
+	assert.Equal([]string{"a", "b", "c"}, sent)
+}
+
+// Ensures that forward stops sending when cancelled and discards results
+// until the handler is done.
+func TestForwardCancel(t *testing.T) {
+	assert := assert.New(t)
+	results := make(chan string)
+	done := make(chan bool)
+	cancel := make(chan struct{})
+	close(cancel)
+	go func() {
+		for x := 0; x < 3; x++ {
+			results <- "foo"
+		}
+		done <- true
+	}()
+
+	ok := forward(results, done, cancel, func(result string) {
+		t.Errorf("Unexpected call to send")
+	})
+
+	assert.False(ok)
+}
+
+// Ensures that AdaptChannel returns a Handler which sends the Channel's
+// results and returns once it completes.
+func TestAdaptChannel(t *testing.T) {
+	assert := assert.New(t)
+	handler := AdaptChannel(func(msg string, c chan<- string, done chan<- bool) {
+		c <- msg
+		c <- msg
+		done <- true
+	})
+	results := make(chan string, 2)
+
+	handler(context.Background(), &Request{ID: "abc", Channel: "foo", Body: "bar"}, results)
+
+	assert.Equal(2, len(results))
+	assert.Equal("bar", <-results)
+	assert.Equal("bar", <-results)
+}
+
+// Ensures that the Handler returned by AdaptChannel returns once its Context
+// is cancelled even if the Channel hasn't completed.
+func TestAdaptChannelCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(chan bool)
+	defer close(block)
+	handler := AdaptChannel(func(msg string, c chan<- string, done chan<- bool) {
+		<-block
+		done <- true
+	})
+	cancel()
+
+	handler(ctx, &Request{ID: "abc", Channel: "foo", Body: "bar"}, make(chan string))
+}
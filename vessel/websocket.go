@@ -0,0 +1,148 @@
+package vessel
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// websocketPath is where native WebSocket clients connect, relative to
+	// the Vessel's URI.
+	websocketPath = "/ws"
+
+	// websocketWriteTimeout bounds how long writing a frame to a client may
+	// take.
+	websocketWriteTimeout = 10 * time.Second
+
+	// websocketPongTimeout is how long to wait for a client to answer a ping
+	// before treating the connection as dead.
+	websocketPongTimeout = 60 * time.Second
+
+	// websocketPingInterval is how often connections are pinged. It must be
+	// less than websocketPongTimeout.
+	websocketPingInterval = websocketPongTimeout * 9 / 10
+)
+
+// websocketSession adapts a WebSocket connection to the sockjs.Session
+// interface so native WebSocket clients are served exactly like SockJS
+// sessions. Each message is a single text frame in the same JSON format.
+type websocketSession struct {
+	id     string
+	conn   *websocket.Conn
+	closed chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+}
+
+func newWebsocketSession(id string, conn *websocket.Conn) *websocketSession {
+	conn.SetReadDeadline(time.Now().Add(websocketPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(websocketPongTimeout))
+	})
+	return &websocketSession{id: id, conn: conn, closed: make(chan struct{})}
+}
+
+// ID returns the session's ID.
+func (w *websocketSession) ID() string {
+	return w.id
+}
+
+// Recv blocks until the client sends a message.
+func (w *websocketSession) Recv() (string, error) {
+	_, msg, err := w.conn.ReadMessage()
+	return string(msg), err
+}
+
+// Send writes the message to the client.
+func (w *websocketSession) Send(msg string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	return w.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+// Close sends a close frame with the status and reason and closes the
+// connection.
+func (w *websocketSession) Close(status uint32, reason string) error {
+	w.mu.Lock()
+	w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(int(status), reason),
+		time.Now().Add(websocketWriteTimeout))
+	w.mu.Unlock()
+	w.once.Do(func() { close(w.closed) })
+	return w.conn.Close()
+}
+
+// keepalive pings the client until the session is closed so dead connections
+// are detected and proxies don't time out idle ones.
+func (w *websocketSession) keepalive() {
+	ticker := time.NewTicker(websocketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			deadline := time.Now().Add(websocketWriteTimeout)
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+		case <-w.closed:
+			return
+		}
+	}
+}
+
+// websocketHandler upgrades requests to WebSocket connections and serves them
+// as sessions of the Vessel.
+type websocketHandler struct {
+	vessel   *sockjsVessel
+	upgrader websocket.Upgrader
+}
+
+func newWebsocketHandler(vessel *sockjsVessel) *websocketHandler {
+	return &websocketHandler{
+		vessel: vessel,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: vessel.cors.allowed,
+		},
+	}
+}
+
+func (h *websocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Unlike SockJS, the upgrade request is available, so a token can be
+	// verified before the connection is accepted.
+	var principal *Principal
+	if h.vessel.authenticator != nil {
+		if token := requestToken(r); token != "" {
+			var err error
+			principal, err = h.vessel.authenticator.Authenticate(token)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+	}
+
+	h.vessel.mu.RLock()
+	closing := h.vessel.closing
+	h.vessel.mu.RUnlock()
+	if closing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The Upgrader has already responded to the client.
+		h.vessel.log.infof("WebSocket upgrade failed: %s", err)
+		return
+	}
+
+	session := newWebsocketSession(h.vessel.idGenerator(), conn)
+	go session.keepalive()
+	h.vessel.serve(session, principal, requestMetadata(r, "websocket"))
+	session.once.Do(func() { close(session.closed) })
+	conn.Close()
+}
@@ -0,0 +1,147 @@
+package vessel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialWebsocket(server *httptest.Server, path string,
+	header http.Header) (*websocket.Conn, *http.Response, error) {
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	return websocket.DefaultDialer.Dial(url, header)
+}
+
+func readWebsocketMessage(t *testing.T, conn *websocket.Conn) *Message {
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+// Ensures that native WebSocket clients can send messages, receive responses
+// and subscribe to broadcasts.
+func TestWebsocketHandler(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{Persister: NewMemoryPersister(0)})
+	vessel.AddChannel("foo", newChannel(t, true))
+	server := httptest.NewServer(vessel.Handler())
+	defer server.Close()
+
+	conn, _, err := dialWebsocket(server, "/foo/ws", nil)
+	if !assert.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(&Message{ID: "abc", Channel: "foo", Body: "hello"})
+	msg := readWebsocketMessage(t, conn)
+	assert.Equal("abc", msg.ID)
+	assert.Equal("foo", msg.Channel)
+	assert.Equal("foo", msg.Body)
+
+	// Messages are handled in order, so the subscription is in place once the
+	// following message has been answered.
+	conn.WriteJSON(&Message{ID: "def", Channel: subscribeChannel, Body: "bar"})
+	conn.WriteJSON(&Message{ID: "ghi", Channel: "foo", Body: "hello"})
+	assert.Equal("ghi", readWebsocketMessage(t, conn).ID)
+
+	vessel.Broadcast("bar", "baz")
+	msg = readWebsocketMessage(t, conn)
+	assert.Equal("bar", msg.Channel)
+	assert.Equal("baz", msg.Body)
+}
+
+// Ensures that WebSocket connections presenting an invalid token are
+// rejected before being upgraded.
+func TestWebsocketHandlerInvalidToken(t *testing.T) {
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{
+		Persister:     NewMemoryPersister(0),
+		Authenticator: AuthenticatorFunc(mockAuthenticator),
+	})
+	server := httptest.NewServer(vessel.Handler())
+	defer server.Close()
+
+	_, resp, err := dialWebsocket(server, "/foo/ws?token=bad", nil)
+
+	assert.NotNil(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+// Ensures that WebSocket connections authenticated with a token may send
+// messages without authenticating on the auth channel.
+func TestWebsocketHandlerToken(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{
+		Persister:     NewMemoryPersister(0),
+		Authenticator: AuthenticatorFunc(mockAuthenticator),
+	})
+	vessel.AddChannel("foo", newChannel(t, true))
+	server := httptest.NewServer(vessel.Handler())
+	defer server.Close()
+
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	conn, _, err := dialWebsocket(server, "/foo/ws", header)
+	if !assert.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(&Message{ID: "abc", Channel: "foo", Body: "hello"})
+	assert.Equal("abc", readWebsocketMessage(t, conn).ID)
+}
+
+// Ensures that WebSocket connections from origins the CORSConfig doesn't
+// allow are rejected.
+func TestWebsocketHandlerOrigin(t *testing.T) {
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{
+		Persister: NewMemoryPersister(0),
+		CORS:      &CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+	})
+	server := httptest.NewServer(vessel.Handler())
+	defer server.Close()
+
+	_, resp, err := dialWebsocket(server, "/foo/ws", http.Header{"Origin": {"https://evil.com"}})
+
+	assert.NotNil(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+}
+
+// Ensures that websocketSession sends close frames with the status and
+// reason.
+func TestWebsocketSessionClose(t *testing.T) {
+	assert := assert.New(t)
+	vessel := NewSockJSVesselWithOptions("/foo", &Options{Persister: NewMemoryPersister(0)})
+	vessel.AddChannel("foo", newChannel(t, true))
+	server := httptest.NewServer(vessel.Handler())
+	defer server.Close()
+
+	conn, _, err := dialWebsocket(server, "/foo/ws", nil)
+	if !assert.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	// Shutdown closes connected sessions.
+	conn.WriteJSON(&Message{ID: "abc", Channel: "foo", Body: "hello"})
+	readWebsocketMessage(t, conn)
+	vessel.Shutdown(context.Background())
+
+	_, _, err = conn.ReadMessage()
+	if assert.IsType(&websocket.CloseError{}, err) {
+		closeErr := err.(*websocket.CloseError)
+		assert.Equal(shutdownStatus, closeErr.Code)
+		assert.Equal(shutdownReason, closeErr.Text)
+	}
+}